@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// logAccess wraps a read handler so every request is written to the access
+// log before it is served. A request that cannot be logged is not served.
+func (a *API) logAccess(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		entry := entity.AccessEntry{
+			Request:  r.Method + " " + r.URL.RequestURI(),
+			ClientIP: clientIP(r),
+		}
+		if id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64); err == nil && id > 0 {
+			entry.RecordID = id
+		}
+		if version, err := strconv.ParseInt(query.Get("version"), 10, 32); err == nil && version > 0 {
+			entry.Version = int(version)
+		}
+		if asOf, err := time.Parse(time.RFC3339, query.Get("as_of")); err == nil {
+			entry.AsOf = &asOf
+		}
+		if knownAt, err := time.Parse(time.RFC3339, query.Get("known_at")); err == nil {
+			entry.KnownAt = &knownAt
+		}
+
+		err := a.recordsV2.LogAccess(r.Context(), entry)
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// clientIP returns the address the request came from, without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseAccessFilter reads the optional actor, id, since and until query
+// parameters.
+func parseAccessFilter(query url.Values) (storage.AccessFilter, error) {
+	filter := storage.AccessFilter{Actor: query.Get("actor")}
+	if value := query.Get("id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			return filter, errors.New("invalid id; must be a positive number")
+		}
+		filter.RecordID = id
+	}
+	times := map[string]*time.Time{"since": &filter.Since, "until": &filter.Until}
+	for name, target := range times {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s; must be an RFC3339 timestamp", name)
+			}
+			*target = parsed
+		}
+	}
+	return filter, nil
+}
+
+// GET /access?actor=<caller>&id=<id>&since=<time>&until=<time>
+// GetAccessLogV2 returns the read-access log entries matching the filters,
+// oldest first.
+func (a *API) GetAccessLogV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	filter, err := parseAccessFilter(r.URL.Query())
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	entries, err := a.recordsV2.GetAccessEntries(ctx, filter)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"entries": entries}, http.StatusOK)
+	logError(err)
+}
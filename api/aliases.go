@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/service"
+)
+
+// readTime returns the time a read looks at: the as_of (or known_at) query
+// parameter, or now. An invalid time is left for the handler to reject.
+func readTime(r *http.Request) time.Time {
+	if asOf, _, err := parseBitemporal(r.URL.Query()); err == nil && !asOf.IsZero() {
+		return asOf
+	}
+	return time.Now()
+}
+
+// readTimes returns the valid and transaction times a read looks at: the
+// as_of and known_at query parameters as parseBitemporal fills them in, or
+// now for both. An invalid time is left for the handler to reject.
+func readTimes(r *http.Request) (asOf, knownAt time.Time) {
+	asOf, knownAt, err := parseBitemporal(r.URL.Query())
+	if err != nil || asOf.IsZero() {
+		now := time.Now()
+		return now, now
+	}
+	return asOf, knownAt
+}
+
+// resolveAlias wraps a read handler so the {id} path variable may also be an
+// alias, "namespace:key", such as policy_number:PN-12345. The alias is
+// resolved as of the read's time (see readTime), and the handlers after it
+// see the id of the record it named. It runs before authorize so the access
+// policies apply to the record, not the alias.
+func (a *API) resolveAlias(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		separator := strings.Index(vars["id"], ":")
+		if separator < 0 {
+			next(w, r)
+			return
+		}
+
+		alias := vars["id"]
+		id, err := a.recordsV2.ResolveAlias(r.Context(), alias[:separator], alias[separator+1:], readTime(r))
+		if errors.Is(err, service.ErrInvalidAlias) {
+			err := writeError(w, err.Error(), http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		if errors.Is(err, service.ErrAliasNotFound) {
+			err := writeError(w, fmt.Sprintf("alias %s does not name a record", alias), http.StatusNotFound)
+			logError(err)
+			return
+		}
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+
+		resolved := map[string]string{}
+		for name, value := range vars {
+			resolved[name] = value
+		}
+		resolved["id"] = strconv.FormatInt(id, 10)
+		next(w, mux.SetURLVars(r, resolved))
+	}
+}
+
+// GET /records/{id}/aliases
+// GetAliasesV2 lists every alias the record has had, removed ones included.
+func (a *API) GetAliasesV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	aliases, err := a.recordsV2.GetAliases(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"aliases": aliases}, http.StatusOK)
+	logError(err)
+}
+
+// POST /records/{id}/aliases
+// AddAliasV2 gives the record an alias: {"namespace": "policy_number",
+// "key": "PN-12345"}, with an optional RFC3339 "valid_from" to record that
+// it has named the record since an earlier time. A key that already names a
+// record at or after that time is refused with 409 (Conflict).
+func (a *API) AddAliasV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		Namespace string    `json:"namespace"`
+		Key       string    `json:"key"`
+		ValidFrom time.Time `json:"valid_from"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	alias, err := a.recordsV2.AddAlias(ctx, idNumber, body.Namespace, body.Key, body.ValidFrom)
+	if errors.Is(err, service.ErrInvalidAlias) || errors.Is(err, service.ErrEffectiveTimeInFuture) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrAliasTaken) {
+		err := writeError(w, err.Error(), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, alias, http.StatusCreated)
+	logError(err)
+}
+
+// DELETE /records/{id}/aliases/{namespace}/{key}
+// RemoveAliasV2 ends an alias of the record. It stays in the list of aliases
+// with the time it was removed, and still resolves for earlier times.
+func (a *API) RemoveAliasV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	alias, err := a.recordsV2.RemoveAlias(ctx, idNumber, vars["namespace"], vars["key"])
+	if errors.Is(err, service.ErrAliasNotActive) {
+		err := writeError(w, err.Error(), http.StatusNotFound)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, alias, http.StatusOK)
+	logError(err)
+}
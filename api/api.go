@@ -1,6 +1,8 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"github.com/temelpa/timetravel/service"
 )
@@ -16,14 +18,67 @@ func NewAPI(records service.RecordService, recordsV2 service.DatabaseService) *A
 
 // generates all api routes
 func (a *API) CreateRoutes(routes *mux.Router) {
-	routes.Path("/records/{id}").HandlerFunc(a.GetRecords).Methods("GET")
-	routes.Path("/records/{id}").HandlerFunc(a.PostRecords).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(a.guard(service.ScopeRead, service.AccessRead, a.logAccess(a.GetRecords))).Methods("GET")
+	routes.Path("/records/{id}").HandlerFunc(a.guard(service.ScopeWrite, service.AccessWrite, a.PostRecords)).Methods("POST")
 }
 
 // generates all v2 api routes
 func (a *API) CreateRoutesV2(routes *mux.Router) {
-	routes.Path("/records/{id}").HandlerFunc(a.GetRecordsV2).Methods("GET")
-	routes.Path("/record/{id}").HandlerFunc(a.GetLastestRecordV2).Methods("GET")
-	routes.Path("/records/{id}/{start}/{end}").HandlerFunc(a.GetRecordsBetweenTimestampV2).Methods("GET")
-	routes.Path("/records/{id}").HandlerFunc(a.PostRecordsV2).Methods("POST")
+	read, write, admin := service.ScopeRead, service.ScopeWrite, service.ScopeAdmin
+	// record routes are also checked against the access policies, and every
+	// read is written to the access log. Reads may name the record by an
+	// alias instead of its id, and reads of record data follow merges;
+	// records that were merged into another refuse changes.
+	reads := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.requireScope(read, a.resolveAlias(a.authorize(service.AccessRead, a.logAccess(next))))
+	}
+	dataReads := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.requireScope(read, a.resolveAlias(a.resolveMerges(a.authorize(service.AccessRead, a.logAccess(next)))))
+	}
+	writes := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.guard(write, service.AccessWrite, a.refuseMerged(next))
+	}
+	deletes := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.guard(write, service.AccessDelete, a.refuseMerged(next))
+	}
+	routes.Path("/records").HandlerFunc(writes(a.CreateRecordV2)).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(dataReads(a.GetRecordsV2)).Methods("GET")
+	routes.Path("/record/{id}").HandlerFunc(dataReads(a.GetLastestRecordV2)).Methods("GET")
+	routes.Path("/records/{id}/{start}/{end}").HandlerFunc(dataReads(a.GetRecordsBetweenTimestampV2)).Methods("GET")
+	routes.Path("/records/{id}").HandlerFunc(writes(a.PostRecordsV2)).Methods("POST")
+	routes.Path("/records/{id}").HandlerFunc(writes(a.PutRecordsV2)).Methods("PUT")
+	routes.Path("/records/{id}").HandlerFunc(deletes(a.DeleteRecordsV2)).Methods("DELETE")
+	routes.Path("/records/{id}/revert").HandlerFunc(writes(a.RevertRecordsV2)).Methods("POST")
+	routes.Path("/records/{id}/corrections").HandlerFunc(writes(a.CorrectRecordsV2)).Methods("POST")
+	routes.Path("/records/{id}/tags").HandlerFunc(reads(a.GetRecordTagsV2)).Methods("GET")
+	routes.Path("/records/{id}/tags").HandlerFunc(writes(a.PutRecordTagsV2)).Methods("PUT")
+	routes.Path("/records/{id}/aliases").HandlerFunc(reads(a.GetAliasesV2)).Methods("GET")
+	routes.Path("/records/{id}/aliases").HandlerFunc(writes(a.AddAliasV2)).Methods("POST")
+	routes.Path("/records/{id}/aliases/{namespace}/{key}").HandlerFunc(writes(a.RemoveAliasV2)).Methods("DELETE")
+	routes.Path("/records/{id}/links").HandlerFunc(reads(a.GetLinksV2)).Methods("GET")
+	routes.Path("/records/{id}/links").HandlerFunc(writes(a.AddLinkV2)).Methods("POST")
+	routes.Path("/records/{id}/links/{link_id}").HandlerFunc(writes(a.EndLinkV2)).Methods("DELETE")
+	routes.Path("/records/{id}/merge").HandlerFunc(writes(a.MergeRecordV2)).Methods("POST")
+	routes.Path("/records/{id}/merges").HandlerFunc(reads(a.GetMergesV2)).Methods("GET")
+	routes.Path("/records/{id}/split").HandlerFunc(writes(a.SplitRecordV2)).Methods("POST")
+	routes.Path("/records/{id}/lineage").HandlerFunc(reads(a.GetLineageV2)).Methods("GET")
+	routes.Path("/records/{id}/holds").HandlerFunc(a.requireScope(admin, a.resolveAlias(a.logAccess(a.GetLegalHoldsV2)))).Methods("GET")
+	routes.Path("/records/{id}/holds").HandlerFunc(a.requireScope(admin, a.PlaceLegalHoldV2)).Methods("POST")
+	routes.Path("/holds/{hold_id}/lift").HandlerFunc(a.requireScope(admin, a.LiftLegalHoldV2)).Methods("POST")
+	routes.Path("/records/{id}/audit").HandlerFunc(a.requireScope(admin, a.resolveAlias(a.logAccess(a.GetAuditV2)))).Methods("GET")
+	routes.Path("/records/{id}/history").HandlerFunc(dataReads(a.GetFieldHistoryV2)).Methods("GET")
+	routes.Path("/records/{id}/diff").HandlerFunc(dataReads(a.DiffRecordsV2)).Methods("GET")
+	routes.Path("/records/{id}/validate").HandlerFunc(dataReads(a.ValidateRecordV2)).Methods("GET")
+	routes.Path("/records/{id}/verify").HandlerFunc(reads(a.VerifyRecordsV2)).Methods("GET")
+	routes.Path("/records/{id}/receipt").HandlerFunc(reads(a.GetReceiptV2)).Methods("GET")
+	routes.Path("/receipts/keys").HandlerFunc(a.requireScope(read, a.logAccess(a.GetReceiptKeysV2))).Methods("GET")
+	routes.Path("/types").HandlerFunc(a.requireScope(read, a.logAccess(a.GetRecordTypesV2))).Methods("GET")
+	routes.Path("/types/{name}").HandlerFunc(a.requireScope(read, a.logAccess(a.GetRecordTypeVersionsV2))).Methods("GET")
+	routes.Path("/types/{name}").HandlerFunc(a.requireScope(admin, a.RegisterRecordTypeV2)).Methods("PUT")
+	routes.Path("/access").HandlerFunc(a.requireScope(admin, a.logAccess(a.GetAccessLogV2))).Methods("GET")
+	routes.Path("/export").HandlerFunc(a.requireScope(admin, a.logAccess(a.ExportV2))).Methods("GET")
+	routes.Path("/import").HandlerFunc(a.requireScope(admin, a.ImportV2)).Methods("POST")
+	routes.Path("/admin/keys").HandlerFunc(a.requireScope(admin, a.logAccess(a.GetAPIKeysV2))).Methods("GET")
+	routes.Path("/admin/keys").HandlerFunc(a.requireScope(admin, a.IssueAPIKeyV2)).Methods("POST")
+	routes.Path("/admin/keys/{key_id}/revoke").HandlerFunc(a.requireScope(admin, a.RevokeAPIKeyV2)).Methods("POST")
 }
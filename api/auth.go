@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/service"
+)
+
+// requireScope wraps a handler so it only runs for callers whose credentials
+// carry scope. A token or API key is sent as "Authorization: Bearer <token>",
+// and an API key may also be sent in the X-API-Key header. Missing or invalid
+// credentials get 401, credentials without the scope 403.
+func (a *API) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		principal, err := a.recordsV2.Authenticate(ctx, credentials(r))
+		if errors.Is(err, service.ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="timetravel"`)
+			err := writeError(w, err.Error(), http.StatusUnauthorized)
+			logError(err)
+			return
+		}
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+		if !principal.HasScope(scope) {
+			err := writeError(w, fmt.Sprintf("credentials lack the %s scope", scope), http.StatusForbidden)
+			logError(err)
+			return
+		}
+
+		next(w, r.WithContext(service.WithPrincipal(ctx, principal)))
+	}
+}
+
+// authorize wraps a handler so it only runs when the access policies allow
+// the caller to perform operation on the record in the {id} path variable.
+// Requests with an invalid id are left for the handler to reject.
+func (a *API) authorize(operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil {
+			next(w, r)
+			return
+		}
+
+		err = a.recordsV2.Authorize(r.Context(), operation, id)
+		if errors.Is(err, service.ErrForbidden) {
+			err := writeError(w, err.Error(), http.StatusForbidden)
+			logError(err)
+			return
+		}
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// guard requires scope and, for the record in the path, operation.
+func (a *API) guard(scope, operation string, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireScope(scope, a.authorize(operation, next))
+}
+
+// credentials returns the token or API key sent with the request, if any.
+func credentials(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// POST /admin/keys
+// IssueAPIKeyV2 issues an API key: {"name": "...", "scopes": ["records:read"]}
+// with optional "roles". The response is the only time the key is shown.
+func (a *API) IssueAPIKeyV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Roles  []string `json:"roles"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	token, key, err := a.recordsV2.IssueAPIKey(ctx, body.Name, body.Scopes, body.Roles)
+	if errors.Is(err, service.ErrKeyIncomplete) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"key": token, "api_key": key}, http.StatusCreated)
+	logError(err)
+}
+
+// GET /admin/keys
+// GetAPIKeysV2 lists every issued key, revoked ones included, without the
+// keys themselves.
+func (a *API) GetAPIKeysV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	keys, err := a.recordsV2.GetAPIKeys(ctx)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"keys": keys}, http.StatusOK)
+	logError(err)
+}
+
+// POST /admin/keys/{key_id}/revoke
+// RevokeAPIKeyV2 revokes an active key.
+func (a *API) RevokeAPIKeyV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	keyID, err := strconv.ParseInt(mux.Vars(r)["key_id"], 10, 32)
+	if err != nil || keyID <= 0 {
+		err := writeError(w, "invalid key id; must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	key, err := a.recordsV2.RevokeAPIKey(ctx, int(keyID))
+	if errors.Is(err, service.ErrKeyNotActive) {
+		err := writeError(w, err.Error(), http.StatusNotFound)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, key, http.StatusOK)
+	logError(err)
+}
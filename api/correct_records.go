@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/service"
+)
+
+// correctionRequest is the body of a correction: the change, in the default
+// update format, and when it actually took effect.
+type correctionRequest struct {
+	EffectiveAt time.Time    `json:"effective_at"`
+	Data        entity.Patch `json:"data"`
+}
+
+// POST /records/{id}/corrections
+// CorrectRecordsV2 applies a change retroactively at its effective time and
+// replays the later versions on top of it as new versions.
+func (a *API) CorrectRecordsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body correctionRequest
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.EffectiveAt.IsZero() || body.Data == nil {
+		err := writeError(w, "invalid input; expected {\"effective_at\": <RFC3339 time>, \"data\": {...}}", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	records, err := a.recordsV2.CorrectRecord(ctx, idNumber, body.EffectiveAt, body.Data)
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, entity.ErrInvalidPath) {
+		err := writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrEffectiveTimeInFuture) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string][]entity.Record{"records": records}, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/temelpa/timetravel/service"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// flushWriter flushes after every write so NDJSON lines reach the client as
+// soon as they are encoded instead of piling up in the response buffer.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// parseExportFilter reads the optional min_id, max_id, since, until, min_seq
+// and max_seq query parameters.
+func parseExportFilter(query url.Values) (storage.ExportFilter, error) {
+	var filter storage.ExportFilter
+	numbers := map[string]*int64{
+		"min_id":  &filter.MinID,
+		"max_id":  &filter.MaxID,
+		"min_seq": &filter.MinSeq,
+		"max_seq": &filter.MaxSeq,
+	}
+	for name, target := range numbers {
+		if value := query.Get(name); value != "" {
+			number, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || number <= 0 {
+				return filter, fmt.Errorf("invalid %s; must be a positive number", name)
+			}
+			*target = number
+		}
+	}
+	times := map[string]*time.Time{"since": &filter.Since, "until": &filter.Until}
+	for name, target := range times {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s; must be an RFC3339 timestamp", name)
+			}
+			*target = parsed
+		}
+	}
+	return filter, nil
+}
+
+// GET /export
+// ExportV2 streams every matching record version as NDJSON.
+func (a *API) ExportV2(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseExportFilter(r.URL.Query())
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	// an export takes as long as the history it holds, so the server's write
+	// timeout does not apply to it
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	logError(err)
+
+	w.Header().Add("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	// the status is already sent, so failures part way through can only be logged
+	_, err = a.recordsV2.ExportRecords(r.Context(), filter, flushWriter{w})
+	logError(err)
+}
+
+// POST /import
+// ImportV2 loads NDJSON produced by the export endpoint.
+func (a *API) ImportV2(w http.ResponseWriter, r *http.Request) {
+	// nor does the server's read timeout to an import
+	err := http.NewResponseController(w).SetReadDeadline(time.Time{})
+	logError(err)
+
+	imported, skipped, err := a.recordsV2.ImportRecords(r.Context(), r.Body)
+	if writeTypeError(w, err) {
+		return
+	}
+	if errors.Is(err, service.ErrImportConflict) {
+		err := writeError(w, fmt.Sprintf("import failed: %v", err), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if err != nil {
+		err := writeError(w, fmt.Sprintf("import failed: %v", err), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	err = writeJSON(w, map[string]int{"imported": imported, "skipped": skipped}, http.StatusOK)
+	logError(err)
+}
@@ -1,13 +1,16 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
 )
 
 // GET /records/{id}
@@ -16,7 +19,7 @@ func (a *API) GetRecords(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
 
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil || idNumber <= 0 {
 		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
@@ -26,7 +29,7 @@ func (a *API) GetRecords(w http.ResponseWriter, r *http.Request) {
 
 	record, err := a.records.GetRecord(
 		ctx,
-		int(idNumber),
+		idNumber,
 	)
 	if err != nil {
 		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
@@ -38,37 +41,48 @@ func (a *API) GetRecords(w http.ResponseWriter, r *http.Request) {
 	logError(err)
 }
 
-// GET /records/{id}
-// GetRecordV2 retrieves the record.
+// GET /records/{id}?upgrade=true
+// GetRecordV2 retrieves the record. With upgrade, every version is migrated
+// to the latest schema of the record's type.
 func (a *API) GetRecordsV2(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
 
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil || idNumber <= 0 {
 		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
 		logError(err)
 		return
 	}
-	record, err := a.recordsV2.GetAllRecordsByID(ctx, int(idNumber))
+	record, err := a.recordsV2.GetAllRecordsByID(ctx, idNumber)
 	if err != nil {
 		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
 		logError(err)
 		return
 	}
+	record, ok := a.upgradeRecords(w, r, record)
+	if !ok {
+		return
+	}
 
 	err = writeJSON(w, record, http.StatusOK)
 	logError(err)
 }
 
-// GET /record/{id}
+// GET /record/{id}?as_of=<time>&known_at=<time>&upgrade=true
 // GetRecord retrieves the latest record.
+//
+// With as_of it retrieves the version in effect at that time, and with
+// known_at it answers as of what had been recorded by then, which shows the
+// record before a later correction. known_at defaults to now; a lone
+// known_at also sets as_of. With upgrade, the version is migrated to the
+// latest schema of the record's type.
 func (a *API) GetLastestRecordV2(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
 
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil || idNumber <= 0 {
 		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
@@ -76,16 +90,29 @@ func (a *API) GetLastestRecordV2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record, err := a.recordsV2.GetLastestRecordByID(
-		ctx,
-		int(idNumber),
-	)
+	asOf, knownAt, err := parseBitemporal(r.URL.Query())
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var record entity.Record
+	if asOf.IsZero() {
+		record, err = a.recordsV2.GetLastestRecordByID(ctx, idNumber)
+	} else {
+		record, err = a.recordsV2.GetRecordAsKnownAt(ctx, idNumber, asOf, knownAt)
+	}
 	if err != nil {
 		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
 		logError(err)
 		return
 	}
-	err = writeJSON(w, record, http.StatusOK)
+	upgraded, ok := a.upgradeRecords(w, r, []entity.Record{record})
+	if !ok {
+		return
+	}
+	err = writeJSON(w, upgraded[0], http.StatusOK)
 	if err != nil {
 		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
 		logError(err)
@@ -93,15 +120,16 @@ func (a *API) GetLastestRecordV2(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /records/{id}/{start}/{end}
-// GetRecordsBetweenTimestamp retrieves the record.
+// GET /records/{id}/{start}/{end}?upgrade=true
+// GetRecordsBetweenTimestamp retrieves the record. upgrade works as for
+// GET /records/{id}.
 func (a *API) GetRecordsBetweenTimestampV2(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
 	startTimeString := mux.Vars(r)["start"]
 	endTimeString := mux.Vars(r)["end"]
 
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil || idNumber <= 0 {
 		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
@@ -134,7 +162,7 @@ func (a *API) GetRecordsBetweenTimestampV2(w http.ResponseWriter, r *http.Reques
 	log.Printf("end time: %v", endTime)
 	record, err := a.recordsV2.GetRecordsByIDBetweenTimestamp(
 		ctx,
-		int(idNumber),
+		idNumber,
 		startTime,
 		endTime,
 	)
@@ -143,6 +171,10 @@ func (a *API) GetRecordsBetweenTimestampV2(w http.ResponseWriter, r *http.Reques
 		logError(err)
 		return
 	}
+	record, ok := a.upgradeRecords(w, r, record)
+	if !ok {
+		return
+	}
 
 	err = writeJSON(w, record, http.StatusOK)
 	if err != nil {
@@ -151,3 +183,28 @@ func (a *API) GetRecordsBetweenTimestampV2(w http.ResponseWriter, r *http.Reques
 		return
 	}
 }
+
+// parseBitemporal reads the optional as_of and known_at query parameters.
+// known_at defaults to now and as_of to known_at; if neither is given both
+// are zero.
+func parseBitemporal(query url.Values) (asOf time.Time, knownAt time.Time, err error) {
+	if value := query.Get("as_of"); value != "" {
+		asOf, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return asOf, knownAt, errors.New("invalid as_of; must be an RFC3339 timestamp")
+		}
+	}
+	if value := query.Get("known_at"); value != "" {
+		knownAt, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return asOf, knownAt, errors.New("invalid known_at; must be an RFC3339 timestamp")
+		}
+	}
+	switch {
+	case asOf.IsZero() && !knownAt.IsZero():
+		asOf = knownAt
+	case knownAt.IsZero() && !asOf.IsZero():
+		knownAt = time.Now()
+	}
+	return asOf, knownAt, nil
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/service"
+)
+
+// GET /records/{id}/history?path=<path>
+// GetFieldHistoryV2 lists the versions in which the value at path changed,
+// with the value it took. Paths address nested values, as in
+// contacts.primary.phone or locations.0.city.
+func (a *API) GetFieldHistoryV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	changes, err := a.recordsV2.GetFieldHistory(ctx, idNumber, path)
+	if errors.Is(err, entity.ErrInvalidPath) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"path": path, "changes": changes}, http.StatusOK)
+	logError(err)
+}
+
+// GET /records/{id}/diff?from=<version>&to=<version>&path=<path>
+// DiffRecordsV2 returns the patch that turns version from into version to,
+// addressing the nested values that changed. to defaults to the latest
+// version and from to the one before to; version 0 is the empty record. A
+// path limits the diff to the values at or below it.
+func (a *API) DiffRecordsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	query := r.URL.Query()
+	versions := map[string]int64{"from": -1, "to": -1}
+	for name := range versions {
+		if value := query.Get(name); value != "" {
+			versions[name], err = strconv.ParseInt(value, 10, 32)
+			if err != nil || versions[name] < 0 {
+				err := writeError(w, fmt.Sprintf("invalid %s; must be a version number", name), http.StatusBadRequest)
+				logError(err)
+				return
+			}
+		}
+	}
+	if versions["to"] < 0 {
+		latest, err := a.recordsV2.GetLastestRecordByID(ctx, idNumber)
+		if errors.Is(err, service.ErrRecordDoesNotExist) {
+			err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+		versions["to"] = int64(latest.Version)
+	}
+	if versions["from"] < 0 {
+		versions["from"] = versions["to"] - 1
+		if versions["from"] < 0 {
+			versions["from"] = 0
+		}
+	}
+
+	diff, err := a.recordsV2.DiffVersions(ctx, idNumber, int(versions["from"]), int(versions["to"]), query.Get("path"))
+	if errors.Is(err, entity.ErrInvalidPath) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v has no version %v or %v", idNumber, versions["from"], versions["to"]), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, diff, http.StatusOK)
+	logError(err)
+}
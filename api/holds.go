@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/service"
+)
+
+// POST /records/{id}/holds
+// PlaceLegalHoldV2 puts the record under legal hold on behalf of the caller.
+// The body names the case: {"case_ref": "..."}.
+func (a *API) PlaceLegalHoldV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		CaseRef string `json:"case_ref"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	hold, err := a.recordsV2.PlaceLegalHold(ctx, idNumber, body.CaseRef)
+	if errors.Is(err, service.ErrHoldIncomplete) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, hold, http.StatusCreated)
+	logError(err)
+}
+
+// GET /records/{id}/holds
+// GetLegalHoldsV2 lists every hold placed on the record, lifted ones included.
+func (a *API) GetLegalHoldsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	holds, err := a.recordsV2.GetLegalHolds(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"holds": holds}, http.StatusOK)
+	logError(err)
+}
+
+// POST /holds/{hold_id}/lift
+// LiftLegalHoldV2 lifts an active hold on behalf of the caller.
+func (a *API) LiftLegalHoldV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	holdID, err := strconv.ParseInt(mux.Vars(r)["hold_id"], 10, 32)
+	if err != nil || holdID <= 0 {
+		err := writeError(w, "invalid hold id; must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	hold, err := a.recordsV2.LiftLegalHold(ctx, int(holdID))
+	if errors.Is(err, service.ErrHoldNotActive) {
+		err := writeError(w, err.Error(), http.StatusNotFound)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, hold, http.StatusOK)
+	logError(err)
+}
+
+// GET /records/{id}/audit
+// GetAuditV2 returns the audit trail of the record.
+func (a *API) GetAuditV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	entries, err := a.recordsV2.GetAuditEntries(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"entries": entries}, http.StatusOK)
+	logError(err)
+}
+
+// DELETE /records/{id}
+// DeleteRecordsV2 marks the record deleted. Its history is kept. The request
+// is refused with 423 (Locked) while the record is under legal hold.
+func (a *API) DeleteRecordsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	err = a.recordsV2.DeleteRecord(ctx, idNumber)
+	if errors.Is(err, service.ErrRecordOnHold) {
+		err := writeError(w, fmt.Sprintf("record of id %v is under legal hold and cannot be deleted", idNumber), http.StatusLocked)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]int64{"deleted": idNumber}, http.StatusOK)
+	logError(err)
+}
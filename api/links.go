@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/service"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// GET /records/{id}/links?as_of=<time>&type=<type>&direction=<out|in>
+// GetLinksV2 lists the links from and to the record. With as_of only the
+// links in effect at that time are listed, type keeps one type of link and
+// direction keeps the links from ("out") or to ("in") the record.
+func (a *API) GetLinksV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.LinkFilter{Type: query.Get("type"), Direction: query.Get("direction")}
+	if filter.Direction != "" && filter.Direction != "out" && filter.Direction != "in" {
+		err := writeError(w, "invalid direction; must be out or in", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if value := query.Get("as_of"); value != "" {
+		filter.AsOf, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			err := writeError(w, "invalid as_of; must be an RFC3339 timestamp", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+
+	links, err := a.recordsV2.GetLinks(ctx, idNumber, filter)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"links": links}, http.StatusOK)
+	logError(err)
+}
+
+// POST /records/{id}/links
+// AddLinkV2 links the record to another: {"type": "employs", "to_id": 42},
+// with optional RFC3339 "valid_from" (default now) and "valid_to" (default
+// open) bounding when the link is in effect.
+func (a *API) AddLinkV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		Type      string     `json:"type"`
+		ToID      int64      `json:"to_id"`
+		ValidFrom time.Time  `json:"valid_from"`
+		ValidTo   *time.Time `json:"valid_to"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	link, err := a.recordsV2.AddLink(ctx, entity.Link{
+		Type:      body.Type,
+		FromID:    idNumber,
+		ToID:      body.ToID,
+		ValidFrom: body.ValidFrom,
+		ValidTo:   body.ValidTo,
+	})
+	if errors.Is(err, service.ErrInvalidLinkType) || errors.Is(err, service.ErrInvalidLink) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, "both linked records must exist", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrForbidden) {
+		err := writeError(w, err.Error(), http.StatusForbidden)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrLinkOverlaps) {
+		err := writeError(w, err.Error(), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, link, http.StatusCreated)
+	logError(err)
+}
+
+// DELETE /records/{id}/links/{link_id}?at=<time>
+// EndLinkV2 ends a link from or to the record at the given time, or now. The
+// link stays listed with its interval.
+func (a *API) EndLinkV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	idNumber, err := strconv.ParseInt(vars["id"], 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	linkID, err := strconv.ParseInt(vars["link_id"], 10, 32)
+	if err != nil || linkID <= 0 {
+		err := writeError(w, "invalid link id; must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var at time.Time
+	if value := r.URL.Query().Get("at"); value != "" {
+		at, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			err := writeError(w, "invalid at; must be an RFC3339 timestamp", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+
+	link, err := a.recordsV2.EndLink(ctx, idNumber, int(linkID), at)
+	if errors.Is(err, service.ErrLinkNotActive) {
+		err := writeError(w, fmt.Sprintf("link %d of record %d does not exist or is not in effect at that time", linkID, idNumber), http.StatusNotFound)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, link, http.StatusOK)
+	logError(err)
+}
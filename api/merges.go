@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/service"
+)
+
+// resolveMerges wraps a handler reading record data so reads of a record
+// that was merged into another are served from the record it was merged
+// into, if the merge was in effect at the read's as_of time and recorded by
+// its known_at time (see readTimes). With redirect=false the merged record
+// itself is read, so its own history stays available. Reads about the record
+// rather than its data, such as its holds, audit trail or merges, are never
+// redirected.
+func (a *API) resolveMerges(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.ParseInt(vars["id"], 10, 64)
+		if err != nil || id <= 0 || r.URL.Query().Get("redirect") == "false" {
+			next(w, r)
+			return
+		}
+
+		asOf, knownAt := readTimes(r)
+		resolvedID, err := a.recordsV2.ResolveMerges(r.Context(), id, asOf, knownAt)
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+		if resolvedID == id {
+			next(w, r)
+			return
+		}
+
+		resolved := map[string]string{}
+		for name, value := range vars {
+			resolved[name] = value
+		}
+		resolved["id"] = strconv.FormatInt(resolvedID, 10)
+		next(w, mux.SetURLVars(r, resolved))
+	}
+}
+
+// refuseMerged wraps a write handler so changes to a record that was merged
+// into another are refused with 409 (Conflict); they belong to the record it
+// was merged into.
+func (a *API) refuseMerged(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+		if err != nil || id <= 0 {
+			next(w, r)
+			return
+		}
+
+		into, err := a.recordsV2.MergedInto(r.Context(), id)
+		if err != nil {
+			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+			logError(err)
+			logError(errInWriting)
+			return
+		}
+		if into != 0 {
+			err := writeError(w, fmt.Sprintf("record of id %v was merged into %v", id, into), http.StatusConflict)
+			logError(err)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// POST /records/{id}/merge
+// MergeRecordV2 merges the record, a duplicate, into another: {"into": 42,
+// "strategy": "keep_into"}, with an optional RFC3339 "merged_at" from which
+// the merge is in effect, no earlier than the latest version of either
+// record. The strategy decides keys both records have with
+// different values: keep_into keeps the value of the record merged into,
+// keep_from takes the value of this record and reject refuses the merge
+// with 409 (Conflict), listing the keys. A record that changes while the
+// merge is made also gets 409. The response holds the merge and the new
+// version of the record merged into.
+func (a *API) MergeRecordV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		Into     int64     `json:"into"`
+		Strategy string    `json:"strategy"`
+		MergedAt time.Time `json:"merged_at"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	merge, record, err := a.recordsV2.MergeRecord(ctx, idNumber, body.Into, body.Strategy, body.MergedAt)
+	var conflict *service.MergeConflictError
+	if errors.As(err, &conflict) {
+		err := writeJSON(w, map[string]interface{}{"error": conflict.Error(), "conflicts": conflict.Keys}, http.StatusConflict)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrInvalidMerge) || errors.Is(err, service.ErrEffectiveTimeInFuture) || errors.Is(err, service.ErrMergeTooEarly) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, "both merged records must exist", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrForbidden) {
+		err := writeError(w, err.Error(), http.StatusForbidden)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordMerged) || errors.Is(err, service.ErrRecordChanged) {
+		err := writeError(w, err.Error(), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"merge": merge, "record": record}, http.StatusOK)
+	logError(err)
+}
+
+// GET /records/{id}/merges
+// GetMergesV2 lists the merges of the record into another and of other
+// records into it.
+func (a *API) GetMergesV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	merges, err := a.recordsV2.GetMerges(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"merges": merges}, http.StatusOK)
+	logError(err)
+}
@@ -3,6 +3,9 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
 
@@ -17,7 +20,7 @@ import (
 func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
 	if err != nil || idNumber <= 0 {
 		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
@@ -37,15 +40,15 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 	// first retrieve the record
 	record, err := a.records.GetRecord(
 		ctx,
-		int(idNumber),
+		idNumber,
 	)
 
 	if !errors.Is(err, service.ErrRecordDoesNotExist) { // record exists
-		record, err = a.records.UpdateRecord(ctx, int(idNumber), body)
+		record, err = a.records.UpdateRecord(ctx, idNumber, body)
 	} else { // record does not exist
 
 		// exclude the delete updates
-		recordMap := map[string]string{}
+		recordMap := map[string]interface{}{}
 		for key, value := range body {
 			if value != nil {
 				recordMap[key] = *value
@@ -53,7 +56,7 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 		}
 
 		record = entity.Record{
-			ID:   int(idNumber),
+			ID:   idNumber,
 			Data: recordMap,
 		}
 		err = a.records.CreateRecord(ctx, record)
@@ -73,78 +76,117 @@ func (a *API) PostRecords(w http.ResponseWriter, r *http.Request) {
 // POST /records/{id}
 // if the record exists, the record is updated.
 // if the record doesn't exist, the record is created.
+//
+// The body format follows the Content-Type: application/merge-patch+json is
+// an RFC 7396 merge patch, application/json-patch+json is an RFC 6902 patch,
+// and anything else is the default map of keys to their new values, which
+// may be any JSON value. Keys are paths, so contacts.primary.phone sets a
+// nested value, and {"$unset": true} deletes what a path addresses.
+//
+// The ?type= parameter gives a record without a type one. The updated data
+// of a typed record must match the current schema of its type.
 func (a *API) PostRecordsV2(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	id := mux.Vars(r)["id"]
-	idNumber, err := strconv.ParseInt(id, 10, 32)
+	idNumber, err := strconv.ParseInt(id, 10, 64)
 
-	if err != nil || idNumber <= 0 {
-		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+	if err != nil || idNumber <= 0 || idNumber > entity.MaxRecordID {
+		err := writeError(w, fmt.Sprintf("invalid id; id must be a positive number up to %d", entity.MaxRecordID), http.StatusBadRequest)
 		logError(err)
 		return
 	}
 
-	var body map[string]*string
-	err = json.NewDecoder(r.Body).Decode(&body)
-
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		err := writeError(w, "invalid input; could not read body", http.StatusBadRequest)
 		logError(err)
 		return
 	}
-	// first retrieve the record
-	record, err := a.recordsV2.GetLastestRecordByID(ctx, int(idNumber))
 
-	if err != nil {
-		// exclude the delete updates
-		recordMap := map[string]string{}
-		for key, value := range body {
-			if value != nil {
-				recordMap[key] = *value
-			}
-		}
+	// first retrieve the record; a missing record starts out empty
+	operation := entity.OperationUpdate
+	record, err := a.recordsV2.GetLastestRecordByID(ctx, idNumber)
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		operation = entity.OperationCreate
+		record = entity.Record{Data: map[string]interface{}{}}
+	} else if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
 
-		record = entity.Record{
-			ID:   int(idNumber),
-			Data: recordMap,
-		}
-		err = a.recordsV2.CreateRecord(ctx, record)
-		if err != nil {
-			errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
-			logError(err)
-			logError(errInWriting)
-			return
-		}
+	newData, status, err := applyUpdate(r.Header.Get("Content-Type"), body, record.Data)
+	if err != nil {
+		err := writeError(w, err.Error(), status)
+		logError(err)
+		return
 	}
 
-	newData := mergeMapData(body, record.Data)
-	updatedRecord := entity.Record{
-		ID:   int(idNumber),
-		Data: newData,
+	// the patch applies to the version read, so the write fails if another
+	// was stored meanwhile
+	updatedRecord, err := a.recordsV2.CreateRecord(ctx, entity.Record{
+		ID:        idNumber,
+		Seq:       record.Seq,
+		Data:      newData,
+		Operation: operation,
+	}, r.URL.Query().Get("type"))
+	if errors.Is(err, service.ErrRecordAlreadyExists) {
+		err := writeError(w, fmt.Sprintf("record of id %v was created while the request was handled; retry it", idNumber), http.StatusConflict)
+		logError(err)
+		return
 	}
-	err = a.recordsV2.CreateRecord(ctx, updatedRecord)
-	if err != nil {
-		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+	if errors.Is(err, service.ErrRecordChanged) {
+		err := writeError(w, fmt.Sprintf("record of id %v changed while the request was handled; retry it", idNumber), http.StatusConflict)
 		logError(err)
-		logError(errInWriting)
 		return
 	}
-	err = writeJSON(w, updatedRecord, http.StatusOK)
+	if writeTypeError(w, err) {
+		return
+	}
 	if err != nil {
 		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
 		logError(err)
 		logError(errInWriting)
 		return
 	}
+	err = writeJSON(w, updatedRecord, http.StatusOK)
+	logError(err)
 }
 
-func mergeMapData(data map[string]*string, recordData map[string]string) map[string]string {
-	for key, value := range data {
-		if value == nil {
-			delete(recordData, key)
-		} else {
-			recordData[key] = *value
+// applyUpdate applies body to data according to contentType and returns the
+// new data, or the status code to report alongside the error.
+func applyUpdate(contentType string, body []byte, data map[string]interface{}) (map[string]interface{}, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var newData map[string]interface{}
+	var err error
+	switch mediaType {
+	case "application/merge-patch+json":
+		newData, err = service.ApplyMergePatch(data, body)
+	case "application/json-patch+json":
+		newData, err = service.ApplyJSONPatch(data, body)
+	default:
+		var updates entity.Patch
+		err = json.Unmarshal(body, &updates)
+		if errors.Is(err, entity.ErrInvalidValue) {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid input; %v", err)
+		}
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid input; could not parse json")
 		}
+		newData, err = updates.Apply(data)
+	}
+
+	switch {
+	case errors.Is(err, entity.ErrInvalidPath):
+		return nil, http.StatusUnprocessableEntity, err
+	case errors.Is(err, service.ErrPatchTestFailed):
+		return nil, http.StatusConflict, err
+	case errors.Is(err, service.ErrInvalidPatch):
+		return nil, http.StatusUnprocessableEntity, err
+	case err != nil:
+		return nil, http.StatusBadRequest, err
 	}
-	return recordData
+	return newData, http.StatusOK, nil
 }
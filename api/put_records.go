@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/service"
+)
+
+// POST /records
+// CreateRecordV2 creates a record with an id assigned by the server from the
+// body, a JSON object mapping keys to any JSON values, and returns it with
+// 201 (Created). As with PUT, ?type= gives the record a type.
+func (a *API) CreateRecordV2(w http.ResponseWriter, r *http.Request) {
+	data, ok := decodeData(w, r)
+	if !ok {
+		return
+	}
+
+	record, err := a.recordsV2.CreateNewRecord(r.Context(), data, r.URL.Query().Get("type"))
+	if errors.Is(err, service.ErrForbidden) {
+		err := writeError(w, err.Error(), http.StatusForbidden)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordAlreadyExists) {
+		err := writeError(w, "the id assigned to the record was taken while the request was handled; retry it", http.StatusConflict)
+		logError(err)
+		return
+	}
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, record, http.StatusCreated)
+	logError(err)
+}
+
+// PUT /records/{id}
+// PutRecordsV2 replaces the record's data wholesale with the body, a JSON
+// object mapping keys to any JSON values. Keys missing from the body are
+// dropped in the new version, which is recorded as a replacement. As with
+// POST, ?type= gives the record a type and typed records are validated.
+func (a *API) PutRecordsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 || idNumber > entity.MaxRecordID {
+		err := writeError(w, fmt.Sprintf("invalid id; id must be a positive number up to %d", entity.MaxRecordID), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	data, ok := decodeData(w, r)
+	if !ok {
+		return
+	}
+
+	operation := entity.OperationReplace
+	_, err = a.recordsV2.GetLastestRecordByID(ctx, idNumber)
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		operation = entity.OperationCreate
+	} else if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	record, err := a.recordsV2.CreateRecord(ctx, entity.Record{
+		ID:        idNumber,
+		Data:      data,
+		Operation: operation,
+	}, r.URL.Query().Get("type"))
+	if errors.Is(err, service.ErrRecordAlreadyExists) {
+		err := writeError(w, fmt.Sprintf("record of id %v was created while the request was handled; retry it", idNumber), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, record, http.StatusOK)
+	logError(err)
+}
+
+// decodeData reads a body holding the whole data of a record. It writes the
+// error response and returns false if the body is not a JSON object.
+func decodeData(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	var body json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return nil, false
+	}
+
+	// whole data drops keys by omitting them, so {"$unset": true} is
+	// rejected along with other objects
+	data, err := entity.DecodeData(body)
+	if err != nil || data == nil {
+		message := "invalid input; body must be a json object"
+		if errors.Is(err, entity.ErrInvalidValue) {
+			message = fmt.Sprintf("invalid input; %v", err)
+		}
+		err := writeError(w, message, http.StatusBadRequest)
+		logError(err)
+		return nil, false
+	}
+	return data, true
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/receipt"
+	"github.com/temelpa/timetravel/service"
+)
+
+// GET /records/{id}/receipt?version=<n>&as_of=<time>&known_at=<time>
+// GetReceiptV2 returns a record version wrapped in a signed receipt that can
+// be verified offline with the receipt package. It covers the given version,
+// the AS OF view selected like GET /record/{id}, or the latest version.
+func (a *API) GetReceiptV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var version int64
+	if value := r.URL.Query().Get("version"); value != "" {
+		version, err = strconv.ParseInt(value, 10, 32)
+		if err != nil || version <= 0 {
+			err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+			logError(err)
+			return
+		}
+	}
+	asOf, knownAt, err := parseBitemporal(r.URL.Query())
+	if err != nil {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	signed, err := a.recordsV2.IssueReceipt(ctx, idNumber, int(version), asOf, knownAt)
+	if errors.Is(err, service.ErrReceiptsDisabled) {
+		err := writeError(w, err.Error(), http.StatusNotImplemented)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, signed, http.StatusOK)
+	logError(err)
+}
+
+// GET /receipts/keys
+// GetReceiptKeysV2 publishes the public keys receipts are verified with,
+// including retired ones, as base64 encoded raw Ed25519 keys.
+func (a *API) GetReceiptKeysV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	type publicKey struct {
+		ID        string `json:"id"`
+		Algorithm string `json:"algorithm"`
+		PublicKey string `json:"public_key"`
+	}
+	keys := []publicKey{}
+	for id, key := range a.recordsV2.ReceiptKeys(ctx) {
+		keys = append(keys, publicKey{ID: id, Algorithm: receipt.Algorithm, PublicKey: base64.StdEncoding.EncodeToString(key)})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	err := writeJSON(w, map[string]interface{}{"keys": keys}, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/service"
+)
+
+// POST /records/{id}/revert?to=<version|time>
+// RevertRecordsV2 restores the record to an earlier state by appending a new
+// version with the data of the target. The target is either a version number
+// or an RFC3339 time, in which case the version current at that time is used.
+func (a *API) RevertRecordsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	to := r.URL.Query().Get("to")
+	var target entity.Record
+	if version, parseErr := strconv.ParseInt(to, 10, 32); parseErr == nil && version > 0 {
+		target, err = a.recordsV2.GetRecordByVersion(ctx, idNumber, int(version))
+	} else if asOf, parseErr := time.Parse(time.RFC3339, to); parseErr == nil {
+		target, err = a.recordsV2.GetRecordAsOf(ctx, idNumber, asOf)
+	} else {
+		err := writeError(w, "invalid to; must be a positive version number or an RFC3339 time", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v has no version matching %v", idNumber, to), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	record, err := a.recordsV2.RevertRecord(ctx, target)
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, record, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/service"
+)
+
+// POST /records/{id}/split
+// SplitRecordV2 creates a record with an id assigned by the server from
+// top-level keys of the record: {"keys": ["locations"]}, with an optional
+// "version" to take them from (default the latest). The keys move out of
+// the record in a new version unless "keep" is true, which older versions
+// need. The response holds the split, the new record and, if the keys moved,
+// the new version of the source.
+func (a *API) SplitRecordV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var body struct {
+		Keys    []string `json:"keys"`
+		Version int      `json:"version"`
+		Keep    bool     `json:"keep"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil || body.Version < 0 {
+		err := writeError(w, "invalid input; could not parse json", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	split, record, source, err := a.recordsV2.SplitRecord(ctx, idNumber, body.Version, body.Keys, body.Keep)
+	if errors.Is(err, service.ErrInvalidSplit) || errors.Is(err, service.ErrSplitNotLatest) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v does not exist in that version", idNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrForbidden) {
+		err := writeError(w, err.Error(), http.StatusForbidden)
+		logError(err)
+		return
+	}
+	if errors.Is(err, service.ErrRecordChanged) || errors.Is(err, service.ErrRecordAlreadyExists) {
+		err := writeError(w, err.Error(), http.StatusConflict)
+		logError(err)
+		return
+	}
+	if writeTypeError(w, err) {
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	response := map[string]interface{}{"split": split, "record": record}
+	if split.Moved {
+		response["source"] = source
+	}
+	err = writeJSON(w, response, http.StatusCreated)
+	logError(err)
+}
+
+// GET /records/{id}/lineage
+// GetLineageV2 lists the splits of the record into others and the split it
+// was created by.
+func (a *API) GetLineageV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	splits, err := a.recordsV2.GetSplits(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"splits": splits}, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// GET /records/{id}/tags
+// GetRecordTagsV2 lists the tags of a record.
+func (a *API) GetRecordTagsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	tags, err := a.recordsV2.GetRecordTags(ctx, idNumber)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string][]string{"tags": tags}, http.StatusOK)
+	logError(err)
+}
+
+// PUT /records/{id}/tags
+// PutRecordTagsV2 replaces the tags of a record with the body, a JSON array
+// of strings.
+func (a *API) PutRecordTagsV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	var tags []string
+	err = json.NewDecoder(r.Body).Decode(&tags)
+	if err != nil || tags == nil {
+		err := writeError(w, "invalid input; body must be a json array of strings", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	err = a.recordsV2.SetRecordTags(ctx, idNumber, tags)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string][]string{"tags": tags}, http.StatusOK)
+	logError(err)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/schema"
+	"github.com/temelpa/timetravel/service"
+)
+
+// PUT /types/{name}
+// RegisterRecordTypeV2 registers the body, a JSON Schema, as the next
+// version of the named record type. To migrate data written under the
+// previous version, the body is {"schema": ..., "migration": [...]}.
+func (a *API) RegisterRecordTypeV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := mux.Vars(r)["name"]
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		err := writeError(w, "invalid input; could not read body", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	recordType, err := a.recordsV2.RegisterRecordType(ctx, name, body)
+	if errors.Is(err, service.ErrInvalidTypeName) || errors.Is(err, schema.ErrInvalidSchema) || errors.Is(err, entity.ErrInvalidMigration) {
+		err := writeError(w, err.Error(), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, recordType, http.StatusCreated)
+	logError(err)
+}
+
+// GET /types
+// GetRecordTypesV2 lists the latest version of every record type.
+func (a *API) GetRecordTypesV2(w http.ResponseWriter, r *http.Request) {
+	recordTypes, err := a.recordsV2.GetRecordTypes(r.Context())
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"types": recordTypes}, http.StatusOK)
+	logError(err)
+}
+
+// GET /types/{name}
+// GetRecordTypeVersionsV2 lists every version of a record type's schema,
+// oldest first.
+func (a *API) GetRecordTypeVersionsV2(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	versions, err := a.recordsV2.GetRecordTypeVersions(r.Context(), name)
+	if errors.Is(err, service.ErrUnknownType) {
+		err := writeError(w, fmt.Sprintf("record type %v does not exist", name), http.StatusNotFound)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, map[string]interface{}{"versions": versions}, http.StatusOK)
+	logError(err)
+}
+
+// GET /records/{id}/validate
+// ValidateRecordV2 checks a version of the record, the latest unless
+// ?version= names one, against the schema of its type that applied when the
+// version was written.
+func (a *API) ValidateRecordV2(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	idNumber, err := strconv.ParseInt(id, 10, 64)
+
+	if err != nil || idNumber <= 0 {
+		err := writeError(w, "invalid id; id must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		latest, err := a.recordsV2.GetLastestRecordByID(ctx, idNumber)
+		if err != nil {
+			err := writeError(w, fmt.Sprintf("record of id %v does not exist", idNumber), http.StatusBadRequest)
+			logError(err)
+			return
+		}
+		version = strconv.Itoa(latest.Version)
+	}
+	versionNumber, err := strconv.ParseInt(version, 10, 32)
+	if err != nil || versionNumber <= 0 {
+		err := writeError(w, "invalid version; version must be a positive number", http.StatusBadRequest)
+		logError(err)
+		return
+	}
+
+	validation, err := a.recordsV2.ValidateVersion(ctx, idNumber, int(versionNumber))
+	if errors.Is(err, service.ErrRecordDoesNotExist) {
+		err := writeError(w, fmt.Sprintf("record of id %v has no version %v", idNumber, versionNumber), http.StatusBadRequest)
+		logError(err)
+		return
+	}
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return
+	}
+
+	err = writeJSON(w, validation, http.StatusOK)
+	logError(err)
+}
+
+// upgradeRecords upgrades records to the latest schema of their type when
+// the request asks for it with ?upgrade=true. It writes the error response
+// and returns false if that fails.
+func (a *API) upgradeRecords(w http.ResponseWriter, r *http.Request, records []entity.Record) ([]entity.Record, bool) {
+	value := r.URL.Query().Get("upgrade")
+	if value == "" {
+		return records, true
+	}
+	upgrade, err := strconv.ParseBool(value)
+	if err != nil {
+		err := writeError(w, "invalid upgrade; must be true or false", http.StatusBadRequest)
+		logError(err)
+		return nil, false
+	}
+	if !upgrade {
+		return records, true
+	}
+	records, err = a.recordsV2.UpgradeRecords(r.Context(), records)
+	if err != nil {
+		errInWriting := writeError(w, ErrInternal.Error(), http.StatusInternalServerError)
+		logError(err)
+		logError(errInWriting)
+		return nil, false
+	}
+	return records, true
+}
+
+// writeTypeError writes the response for the record type errors of a write
+// and reports whether err was one. Data that does not match the schema is
+// reported field by field.
+func writeTypeError(w http.ResponseWriter, err error) bool {
+	var invalid *service.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		err := writeJSON(w, map[string]interface{}{
+			"error":          err.Error(),
+			"type":           invalid.Type,
+			"schema_version": invalid.SchemaVersion,
+			"errors":         invalid.Errors,
+		}, http.StatusUnprocessableEntity)
+		logError(err)
+	case errors.Is(err, service.ErrUnknownType):
+		err := writeError(w, err.Error(), http.StatusUnprocessableEntity)
+		logError(err)
+	case errors.Is(err, service.ErrTypeMismatch):
+		err := writeError(w, err.Error(), http.StatusConflict)
+		logError(err)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/service"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// commands are the subcommands available besides the default server.
+var commands = map[string]func(args []string) error{
+	"serve":     serve,
+	"export":    exportCommand,
+	"import":    importCommand,
+	"compact":   compactCommand,
+	"verify":    verifyCommand,
+	"reencrypt": reencryptCommand,
+	"keys":      keysCommand,
+}
+
+// openService opens the database at path for a one-off command.
+func openService(path string) (*storage.Storage, service.DatabaseService, error) {
+	db, err := storage.NewStorageAt(path)
+	if err != nil {
+		return nil, service.DatabaseService{}, err
+	}
+	return db, service.NewDatabaseService(db), nil
+}
+
+// parseTimeFlag parses an optional RFC3339 flag value.
+func parseTimeFlag(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s; must be an RFC3339 timestamp", name)
+	}
+	return parsed, nil
+}
+
+// exportCommand writes record history as NDJSON, mirroring GET /api/v2/export.
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	output := flags.String("o", "-", "output file, - for stdout")
+	minID := flags.Int64("min-id", 0, "lowest record id to export")
+	maxID := flags.Int64("max-id", 0, "highest record id to export")
+	minSeq := flags.Int64("min-seq", 0, "lowest sequence number to export")
+	maxSeq := flags.Int64("max-seq", 0, "highest sequence number to export")
+	since := flags.String("since", "", "only versions created at or after this RFC3339 time")
+	until := flags.String("until", "", "only versions created at or before this RFC3339 time")
+	tenant := flags.String("tenant", "", "tenant whose records to export")
+	flags.Parse(args)
+
+	filter := storage.ExportFilter{MinID: *minID, MaxID: *maxID, MinSeq: *minSeq, MaxSeq: *maxSeq}
+	var err error
+	if filter.Since, err = parseTimeFlag("since", *since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTimeFlag("until", *until); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := dbService.ExportRecords(service.WithTenant(context.Background(), *tenant), filter, w)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d versions\n", count)
+	return nil
+}
+
+// importCommand loads NDJSON produced by the export command.
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	input := flags.String("i", "-", "input file, - for stdin")
+	tenant := flags.String("tenant", "", "tenant to import the records into")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	imported, skipped, err := dbService.ImportRecords(service.WithTenant(context.Background(), *tenant), r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d versions, skipped %d already present\n", imported, skipped)
+	return nil
+}
+
+// compactCommand applies the configured retention policies once, to every
+// tenant.
+func compactCommand(args []string) error {
+	flags := flag.NewFlagSet("compact", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	configPath := flags.String("config", "timetravel.json", "path to the JSON configuration file")
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Retention.Policies) == 0 {
+		return fmt.Errorf("%s defines no retention policies", *configPath)
+	}
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	removed, err := dbService.ApplyRetention(context.Background(), cfg.Retention.Policies, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "removed %d versions\n", removed)
+	return nil
+}
+
+// verifyCommand checks the hash chain of every record, or of one with -id,
+// in every tenant or only in the one given with -tenant, and fails when a
+// link is broken.
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	id := flags.Int64("id", 0, "only verify this record")
+	tenant := flags.String("tenant", "", "only verify the records of this tenant")
+	flags.Parse(args)
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// the default tenant is "", so only an explicit -tenant narrows the check
+	tenants := []string{*tenant}
+	explicit := false
+	flags.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "tenant" })
+	if !explicit {
+		tenants, err = dbService.GetTenants(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+
+	checked := 0
+	for _, tenant := range tenants {
+		report, err := dbService.VerifyChain(service.WithTenant(context.Background(), tenant), *id)
+		if errors.Is(err, service.ErrRecordDoesNotExist) && !explicit {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if broken := report.Broken; broken != nil {
+			return fmt.Errorf("hash chain of tenant %q broken at seq %d (record %d version %d): %s",
+				tenant, broken.Seq, broken.ID, broken.Version, broken.Reason)
+		}
+		checked += report.Checked
+	}
+	if *id > 0 && checked == 0 {
+		return service.ErrRecordDoesNotExist
+	}
+	fmt.Fprintf(os.Stderr, "verified %d versions in %d tenants\n", checked, len(tenants))
+	return nil
+}
+
+// reencryptCommand migrates every stored version, in every tenant, to the
+// active encryption key, encrypting fields configured after they were first
+// written. The re-seal checkpoints it records are signed with the receipt
+// key when the configuration has one.
+func reencryptCommand(args []string) error {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	configPath := flags.String("config", "timetravel.json", "path to the JSON configuration file")
+	actor := flags.String("actor", "reencrypt", "who to record in the audit trail")
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	fields, err := service.LoadEncryptionKeys(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	if fields == nil {
+		return fmt.Errorf("%s encrypts no fields", *configPath)
+	}
+	signer, receiptKeys, err := service.LoadReceiptKeys(cfg.Signing)
+	if err != nil {
+		return err
+	}
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	dbService.SetEncryption(fields)
+	dbService.SetReceiptKeys(signer, receiptKeys)
+
+	changed, err := dbService.ReencryptRecords(service.WithActor(context.Background(), *actor))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "re-encrypted %d versions\n", changed)
+	return nil
+}
+
+// keysCommand issues, lists and revokes the API keys of a tenant:
+//
+//	keys issue -name ci -scopes records:read,records:write -roles agent
+//	keys issue -name acme -scopes admin -tenant acme
+//	keys list -tenant acme
+//	keys revoke -id 3
+func keysCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: keys issue|list|revoke [flags]")
+	}
+	flags := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	name := flags.String("name", "", "name of the key to issue")
+	scopes := flags.String("scopes", "", "comma separated scopes of the key to issue")
+	roles := flags.String("roles", "", "comma separated roles of the key to issue")
+	keyID := flags.Int("id", 0, "id of the key to revoke")
+	actor := flags.String("actor", "cli", "who to record in the audit trail")
+	tenant := flags.String("tenant", "", "tenant the key belongs to")
+	flags.Parse(args[1:])
+
+	db, dbService, err := openService(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := service.WithTenant(service.WithActor(context.Background(), *actor), *tenant)
+
+	switch args[0] {
+	case "issue":
+		comma := func(r rune) bool { return r == ',' }
+		token, key, err := dbService.IssueAPIKey(ctx, *name, strings.FieldsFunc(*scopes, comma), strings.FieldsFunc(*roles, comma))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "issued key %d (%s) with scopes %s; it will not be shown again\n",
+			key.ID, key.Name, strings.Join(key.Scopes, ","))
+		fmt.Println(token)
+	case "list":
+		keys, err := dbService.GetAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked " + key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\t%s...\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix,
+				strings.Join(key.Scopes, ","), strings.Join(key.Roles, ","), status)
+		}
+	case "revoke":
+		key, err := dbService.RevokeAPIKey(ctx, *keyID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "revoked key %d (%s)\n", key.ID, key.Name)
+	default:
+		return fmt.Errorf("unknown keys command %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package config loads the optional JSON configuration file of the server.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the contents of the configuration file. Every section is
+// optional; a missing file yields the zero Config.
+type Config struct {
+	Retention  RetentionConfig  `json:"retention"`
+	Signing    SigningConfig    `json:"signing"`
+	Encryption EncryptionConfig `json:"encryption"`
+	JWT        JWTConfig        `json:"jwt"`
+
+	Authorization AuthorizationConfig `json:"authorization"`
+}
+
+// RetentionConfig controls the background compactor.
+type RetentionConfig struct {
+	// Interval is how often the compactor runs. It defaults to an hour.
+	Interval Duration `json:"interval"`
+	// Policies are applied in order on every run.
+	Policies []RetentionPolicy `json:"policies"`
+}
+
+// RetentionPolicy thins out old history: versions recorded more than
+// OlderThan ago are collapsed so only the last one per Keep period remains.
+// A policy applies to records in the inclusive id range and, if Tag is set,
+// only to records carrying that tag.
+type RetentionPolicy struct {
+	Name      string   `json:"name"`
+	MinID     int64    `json:"min_id"`
+	MaxID     int64    `json:"max_id"`
+	Tag       string   `json:"tag"`
+	OlderThan Duration `json:"older_than"`
+	// Keep is one of "last_per_hour", "last_per_day", "last_per_week" or
+	// "last_per_month".
+	Keep string `json:"keep"`
+}
+
+// SigningConfig holds the Ed25519 keys receipts are signed with. New receipts
+// are signed with ActiveKey; the other keys remain published so receipts
+// signed before a rotation can still be verified.
+type SigningConfig struct {
+	ActiveKey string       `json:"active_key"`
+	Keys      []SigningKey `json:"keys"`
+}
+
+// SigningKey is a PEM encoded Ed25519 key pair. Retired keys only need
+// PublicKeyFile; the active key needs PrivateKeyFile, from which the public
+// key is derived.
+type SigningKey struct {
+	ID             string `json:"id"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// EncryptionConfig lists the record fields encrypted at rest and the AES-256
+// keys used for them. New values are encrypted with ActiveKey; older keys
+// stay listed so values encrypted with them can still be read until the
+// re-encryption job has migrated them.
+type EncryptionConfig struct {
+	Fields    []string        `json:"fields"`
+	ActiveKey string          `json:"active_key"`
+	Keys      []EncryptionKey `json:"keys"`
+}
+
+// EncryptionKey names a file holding a base64 encoded 32 byte key, such as
+// one written by `openssl rand -base64 32`.
+type EncryptionKey struct {
+	ID      string `json:"id"`
+	KeyFile string `json:"key_file"`
+}
+
+// JWTConfig enables bearer tokens issued by a gateway alongside API keys.
+// Tokens must be signed with RS256 or EdDSA by a key in the JWKS file and
+// carry the configured issuer and audience.
+type JWTConfig struct {
+	JWKSFile string `json:"jwks_file"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Leeway is the clock skew tolerated on exp and nbf. It defaults to a
+	// minute.
+	Leeway Duration `json:"leeway"`
+	// RolesClaim is the dot separated path of the claim listing the caller's
+	// roles. It defaults to "roles".
+	RolesClaim string `json:"roles_claim"`
+	// TenantClaim is the dot separated path of the claim naming the tenant
+	// the caller acts for. When set, tokens without it are rejected; when
+	// empty, every token acts for the default tenant.
+	TenantClaim string `json:"tenant_claim"`
+	// Roles maps each role to the API scopes it grants.
+	Roles map[string][]string `json:"roles"`
+}
+
+// AuthorizationConfig restricts which records callers may access. Without
+// policies, callers may access every record their scopes allow.
+type AuthorizationConfig struct {
+	Policies []AccessPolicy `json:"policies"`
+}
+
+// AccessPolicy allows callers with one of Roles to perform Operations on the
+// records in the inclusive id range that, if Tag is set, carry that tag.
+// Empty Roles or Operations match any role or operation. A request is
+// allowed if any policy allows it.
+type AccessPolicy struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+	// Operations are "read", "write" and "delete".
+	Operations []string `json:"operations"`
+	MinID      int64    `json:"min_id"`
+	MaxID      int64    `json:"max_id"`
+	Tag        string   `json:"tag"`
+}
+
+// Duration is a time.Duration written in JSON as a string such as "36h" or,
+// for whole days, "90d".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+	parsed, err := ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ParseDuration parses a Go duration, additionally accepting a whole number of
+// days such as "90d".
+func ParseDuration(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Load reads the configuration file at path. A missing file is not an error.
+func Load(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, config.validate()
+}
+
+// validate rejects settings that would otherwise fail at runtime.
+func (c Config) validate() error {
+	for i, policy := range c.Retention.Policies {
+		if !keepValues[policy.Keep] {
+			return fmt.Errorf("retention policy %d (%s): unknown keep %q", i, policy.Name, policy.Keep)
+		}
+		if policy.OlderThan <= 0 {
+			return fmt.Errorf("retention policy %d (%s): older_than must be positive", i, policy.Name)
+		}
+	}
+
+	ids := map[string]bool{}
+	for i, key := range c.Signing.Keys {
+		if key.ID == "" || ids[key.ID] {
+			return fmt.Errorf("signing key %d: id must be set and unique", i)
+		}
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			return fmt.Errorf("signing key %s: needs private_key_file or public_key_file", key.ID)
+		}
+		if key.ID == c.Signing.ActiveKey && key.PrivateKeyFile == "" {
+			return fmt.Errorf("signing key %s: the active key needs private_key_file", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	if c.Signing.ActiveKey != "" && !ids[c.Signing.ActiveKey] {
+		return fmt.Errorf("signing: active_key %q is not among the keys", c.Signing.ActiveKey)
+	}
+
+	ids = map[string]bool{}
+	for i, key := range c.Encryption.Keys {
+		if key.ID == "" || strings.Contains(key.ID, ":") || ids[key.ID] {
+			return fmt.Errorf("encryption key %d: id must be set, unique and free of ':'", i)
+		}
+		if key.KeyFile == "" {
+			return fmt.Errorf("encryption key %s: needs key_file", key.ID)
+		}
+		ids[key.ID] = true
+	}
+	if len(c.Encryption.Fields) > 0 && !ids[c.Encryption.ActiveKey] {
+		return fmt.Errorf("encryption: active_key %q is not among the keys", c.Encryption.ActiveKey)
+	}
+
+	if c.JWT.JWKSFile != "" && (c.JWT.Issuer == "" || c.JWT.Audience == "") {
+		return errors.New("jwt: issuer and audience are required")
+	}
+
+	for i, policy := range c.Authorization.Policies {
+		for _, operation := range policy.Operations {
+			if !accessOperations[operation] {
+				return fmt.Errorf("access policy %d (%s): unknown operation %q", i, policy.Name, operation)
+			}
+		}
+	}
+	return nil
+}
+
+// accessOperations are the supported AccessPolicy.Operations.
+var accessOperations = map[string]bool{
+	"read":   true,
+	"write":  true,
+	"delete": true,
+}
+
+// keepValues are the supported RetentionPolicy.Keep settings.
+var keepValues = map[string]bool{
+	"last_per_hour":  true,
+	"last_per_day":   true,
+	"last_per_week":  true,
+	"last_per_month": true,
+}
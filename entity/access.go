@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+// AccessEntry is one line of the append-only read-access log: who looked at
+// which record, which view of it they asked for, and from where.
+type AccessEntry struct {
+	Seq      int64      `json:"seq"`
+	At       time.Time  `json:"at"`
+	Actor    string     `json:"actor"`
+	RecordID int64      `json:"record_id,omitempty"`
+	Version  int        `json:"version,omitempty"`
+	AsOf     *time.Time `json:"as_of,omitempty"`
+	KnownAt  *time.Time `json:"known_at,omitempty"`
+	Request  string     `json:"request"` // the method and path requested, with its query
+	ClientIP string     `json:"client_ip"`
+}
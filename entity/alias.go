@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+// Alias is an external key, such as a policy number, that names a record
+// from ValidFrom until ValidTo. Within a namespace a key names at most one
+// record at any time.
+type Alias struct {
+	ID         int        `json:"id"`
+	Namespace  string     `json:"namespace"`
+	Key        string     `json:"key"`
+	RecordID   int64      `json:"record_id"`
+	ValidFrom  time.Time  `json:"valid_from"`
+	ValidTo    *time.Time `json:"valid_to,omitempty"`
+	AssignedBy string     `json:"assigned_by"`
+	RemovedBy  string     `json:"removed_by,omitempty"`
+}
+
+// String returns the alias as it is written in paths, "namespace:key".
+func (a *Alias) String() string {
+	return a.Namespace + ":" + a.Key
+}
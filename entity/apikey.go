@@ -0,0 +1,38 @@
+package entity
+
+import "time"
+
+// APIKey describes an issued API key. The key itself is only shown once, when
+// it is issued; only its hash is stored.
+type APIKey struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"` // the start of the key, to tell keys apart
+	Scopes    []string   `json:"scopes"`
+	Roles     []string   `json:"roles,omitempty"`
+	Tenant    string     `json:"tenant,omitempty"` // the only tenant whose records the key can reach
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Principal is the authenticated caller of a request. Its roles select the
+// access policies that apply to it, and its tenant the records it can see.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Scopes  []string `json:"scopes"`
+}
+
+// ScopeAdmin grants every other scope.
+const ScopeAdmin = "admin"
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// ComputeHash returns the chain hash of the version of tenant's record: a
+// SHA-256 over the tenant, its id, version, data, created and effective
+// times, author and the hash of the version stored before it, so a version
+// moved to another tenant no longer verifies. The default tenant is left out
+// of the hashed payload, which keeps the hashes of versions written before
+// tenants valid. Fields that legitimately change after a version is written,
+// such as deleted_at or superseded_at, are not covered.
+func (d *Record) ComputeHash(tenant, prevHash string) string {
+	payload, _ := json.Marshal(struct {
+		Tenant      string                 `json:"tenant,omitempty"`
+		ID          int64                  `json:"id"`
+		Version     int                    `json:"version"`
+		Data        map[string]interface{} `json:"data"`
+		CreatedAt   string                 `json:"created_at"`
+		EffectiveAt string                 `json:"effective_at"`
+		Author      string                 `json:"author,omitempty"`
+		PrevHash    string                 `json:"prev_hash"`
+	}{
+		Tenant:      tenant,
+		ID:          d.ID,
+		Version:     d.Version,
+		Data:        d.Data,
+		CreatedAt:   d.CreatedAt.UTC().Format(time.RFC3339Nano),
+		EffectiveAt: d.EffectiveAt.UTC().Format(time.RFC3339Nano),
+		Author:      d.Author,
+		PrevHash:    prevHash,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainReport is the result of verifying the hash chain of record versions.
+type ChainReport struct {
+	OK      bool        `json:"ok"`
+	Checked int         `json:"checked"`
+	Broken  *ChainBreak `json:"broken,omitempty"`
+}
+
+// ChainBreak describes the first version whose hash chain does not hold.
+type ChainBreak struct {
+	Seq     int64  `json:"seq"`
+	ID      int64  `json:"id"`
+	Version int    `json:"version"`
+	Reason  string `json:"reason"`
+}
+
+// ResealCheckpoint records that the hash chain of a record was sealed again
+// after its stored data was rewritten without changing what it means, such as
+// by re-encryption. It keeps the hash every rewritten version had before, so
+// receipts and copies of the chain taken earlier can still be matched.
+type ResealCheckpoint struct {
+	Tenant     string            `json:"tenant"`
+	RecordID   int64             `json:"record_id"`
+	Action     string            `json:"action"`
+	ResealedAt time.Time         `json:"resealed_at"`
+	Versions   []ResealedVersion `json:"versions"`
+}
+
+// ResealedVersion maps the hash a version had before a reseal to the one it
+// has after.
+type ResealedVersion struct {
+	Seq     int64  `json:"seq"`
+	Version int    `json:"version"`
+	OldHash string `json:"old_hash"`
+	Hash    string `json:"hash"`
+}
@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// FieldChange is a version in which the value at a path changed.
+type FieldChange struct {
+	Version     int         `json:"version"`
+	Operation   string      `json:"operation,omitempty"`
+	Author      string      `json:"author,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	EffectiveAt time.Time   `json:"effective_at"`
+	Value       interface{} `json:"value"`
+	Removed     bool        `json:"removed,omitempty"`   // the path no longer exists; Value is null
+	RecordID    int64       `json:"record_id,omitempty"` // set when the change was made to the record this one was split off
+}
+
+// VersionDiff is the change between two versions of a record.
+type VersionDiff struct {
+	ID      int64 `json:"id"`
+	From    int   `json:"from"`
+	To      int   `json:"to"`
+	Changes Patch `json:"changes"`
+}
@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// LegalHold freezes the history of a record while litigation is pending.
+// A hold is active until it is lifted.
+type LegalHold struct {
+	ID       int        `json:"id"`
+	RecordID int64      `json:"record_id"`
+	CaseRef  string     `json:"case_ref"`
+	PlacedBy string     `json:"placed_by"`
+	PlacedAt time.Time  `json:"placed_at"`
+	LiftedBy string     `json:"lifted_by,omitempty"`
+	LiftedAt *time.Time `json:"lifted_at,omitempty"`
+}
+
+// Active reports whether the hold has not been lifted.
+func (h *LegalHold) Active() bool {
+	return h.LiftedAt == nil
+}
+
+// AuditEntry is one line of the append-only audit trail.
+type AuditEntry struct {
+	Seq      int64     `json:"seq"`
+	At       time.Time `json:"at"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	RecordID int64     `json:"record_id,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+}
@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+// Link is a typed relationship from one record to another, such as a
+// policyholder that employs a person or is located at a location. It is in
+// effect from ValidFrom until ValidTo, or indefinitely if ValidTo is nil.
+type Link struct {
+	ID        int        `json:"id"`
+	Type      string     `json:"type"`
+	FromID    int64      `json:"from_id"`
+	ToID      int64      `json:"to_id"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	EndedBy   string     `json:"ended_by,omitempty"`
+}
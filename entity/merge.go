@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"sort"
+	"time"
+)
+
+// Strategies for keys that both records of a merge have with different values.
+const (
+	// MergeKeepInto keeps the value of the record merged into.
+	MergeKeepInto = "keep_into"
+	// MergeKeepFrom takes the value of the record being merged.
+	MergeKeepFrom = "keep_from"
+	// MergeReject refuses the merge.
+	MergeReject = "reject"
+)
+
+// Merge records that the duplicate FromID was merged into IntoID from
+// MergedAt on, as known from RecordedAt on. Version is the version of IntoID
+// holding the combined data, which is in effect from MergedAt.
+type Merge struct {
+	FromID     int64     `json:"from_id"`
+	IntoID     int64     `json:"into_id"`
+	MergedAt   time.Time `json:"merged_at"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Strategy   string    `json:"strategy"`
+	MergedBy   string    `json:"merged_by"`
+	Version    int       `json:"version"`
+}
+
+// MergeData combines the top-level keys of two records' data. Keys only one
+// of them has are kept, and keys both have with different values are
+// resolved by strategy. With MergeReject and any such keys, nil is returned
+// along with the keys, sorted.
+func MergeData(into, from map[string]interface{}, strategy string) (map[string]interface{}, []string) {
+	combined := CopyData(into)
+	if combined == nil {
+		combined = map[string]interface{}{}
+	}
+	var conflicts []string
+	for key, value := range from {
+		existing, ok := combined[key]
+		if ok && !EqualValues(existing, value) {
+			conflicts = append(conflicts, key)
+			if strategy != MergeKeepFrom {
+				continue
+			}
+		}
+		combined[key] = CopyValue(value)
+	}
+	if strategy == MergeReject && len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, conflicts
+	}
+	return combined, nil
+}
@@ -0,0 +1,204 @@
+package entity
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Migration step operations. Paths use the same syntax as patch keys.
+const (
+	// MigrateRename moves the value at From to To.
+	MigrateRename = "rename"
+	// MigrateRemove deletes the value at Path.
+	MigrateRemove = "remove"
+	// MigrateDefault sets Path to Value if it is missing.
+	MigrateDefault = "default"
+	// MigrateConvert converts the value at Path to Type: string, number,
+	// integer or boolean.
+	MigrateConvert = "convert"
+	// MigrateMap replaces the string at Path with its entry in Values.
+	MigrateMap = "map"
+)
+
+var ErrInvalidMigration = errors.New("invalid migration")
+
+// MigrationStep is one declarative change in a Migration.
+type MigrationStep struct {
+	Op     string                 `json:"op"`
+	Path   string                 `json:"path,omitempty"`
+	From   string                 `json:"from,omitempty"`
+	To     string                 `json:"to,omitempty"`
+	Type   string                 `json:"type,omitempty"`
+	Value  interface{}            `json:"value,omitempty"`
+	Values map[string]interface{} `json:"values,omitempty"`
+}
+
+// Migration upgrades data written under the previous version of a record
+// type's schema to the version it belongs to. Its steps run in order.
+type Migration []MigrationStep
+
+// DecodeMigration decodes a migration, keeping numbers as written.
+func DecodeMigration(raw []byte) (Migration, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var migration Migration
+	if err := decoder.Decode(&migration); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMigration, err)
+	}
+	return migration, migration.Check()
+}
+
+// Check reports the first malformed step.
+func (m Migration) Check() error {
+	for i, step := range m {
+		invalid := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%w: step %d: %s", ErrInvalidMigration, i, fmt.Sprintf(format, args...))
+		}
+		paths := []string{step.Path}
+		switch step.Op {
+		case MigrateRename:
+			paths = []string{step.From, step.To}
+		case MigrateRemove:
+		case MigrateDefault:
+			if step.Value == nil {
+				return invalid("default needs a value")
+			}
+		case MigrateConvert:
+			switch step.Type {
+			case "string", "number", "integer", "boolean":
+			default:
+				return invalid("cannot convert to %q", step.Type)
+			}
+		case MigrateMap:
+			if len(step.Values) == 0 {
+				return invalid("map needs values")
+			}
+		default:
+			return invalid("unknown op %q", step.Op)
+		}
+		var parsed [][]string
+		for _, path := range paths {
+			segments, err := ParsePath(path)
+			if err != nil {
+				return invalid("%v", err)
+			}
+			parsed = append(parsed, segments)
+		}
+		if step.Op == MigrateRename && overlaps(parsed[0], parsed[1]) {
+			return invalid("cannot rename %s into or out of itself", step.From)
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether one path addresses a value inside the other.
+func overlaps(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns a copy of data with the migration applied. A step that does
+// not apply, such as renaming a missing key or converting a value that has
+// no equivalent of the target type, leaves data as it is, so old versions
+// can always be read.
+func (m Migration) Apply(data map[string]interface{}) map[string]interface{} {
+	result := CopyData(data)
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	for _, step := range m {
+		path, _ := ParsePath(step.Path)
+		switch step.Op {
+		case MigrateRename:
+			from, _ := ParsePath(step.From)
+			to, _ := ParsePath(step.To)
+			value, ok := Lookup(result, from)
+			if !ok {
+				continue
+			}
+			if _, exists := Lookup(result, to); exists {
+				continue // never overwrite data with a renamed value
+			}
+			if err := setPath(result, to, value); err == nil {
+				unsetPath(result, from)
+			}
+		case MigrateRemove:
+			unsetPath(result, path)
+		case MigrateDefault:
+			if _, ok := Lookup(result, path); !ok {
+				setPath(result, path, CopyValue(step.Value))
+			}
+		case MigrateConvert:
+			if value, ok := Lookup(result, path); ok {
+				if converted, ok := convertValue(value, step.Type); ok {
+					setPath(result, path, converted)
+				}
+			}
+		case MigrateMap:
+			value, _ := Lookup(result, path)
+			if s, ok := value.(string); ok {
+				if mapped, ok := step.Values[s]; ok {
+					setPath(result, path, CopyValue(mapped))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// convertValue converts a scalar to the named type.
+func convertValue(value interface{}, to string) (interface{}, bool) {
+	switch to {
+	case "string":
+		switch value := value.(type) {
+		case string:
+			return value, true
+		case json.Number:
+			return string(value), true
+		case bool:
+			return strconv.FormatBool(value), true
+		}
+	case "number", "integer":
+		var number *big.Rat
+		switch value := value.(type) {
+		case json.Number:
+			number, _ = new(big.Rat).SetString(string(value))
+		case string:
+			if _, err := DecodeValue([]byte(value)); err == nil {
+				number, _ = new(big.Rat).SetString(value)
+			}
+		}
+		if number == nil {
+			return nil, false
+		}
+		if to == "integer" {
+			if !number.IsInt() {
+				return nil, false
+			}
+			return json.Number(number.Num().String()), true
+		}
+		if n, ok := value.(json.Number); ok {
+			return n, true
+		}
+		return json.Number(value.(string)), true
+	case "boolean":
+		switch value := value.(type) {
+		case bool:
+			return value, true
+		case string:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,155 @@
+package entity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Patch is a change to record data in the default update format: each key is
+// a path (see ParsePath) set to its value, null included, and a path set to
+// Unset is deleted.
+type Patch map[string]interface{}
+
+// Unset is the patch value that deletes a key. It is written as
+// {"$unset": true} in JSON, so it cannot be mistaken for a null value.
+var Unset = unset{}
+
+type unset struct{}
+
+var unsetJSON = []byte(`{"$unset":true}`)
+
+func (unset) MarshalJSON() ([]byte, error) {
+	return unsetJSON, nil
+}
+
+// UnmarshalJSON decodes a patch, keeping the types of its values.
+func (p *Patch) UnmarshalJSON(raw []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return err
+	}
+	if members == nil {
+		*p = nil
+		return nil
+	}
+	patch := make(Patch, len(members))
+	for key, member := range members {
+		if isUnset(member) {
+			patch[key] = Unset
+			continue
+		}
+		value, err := DecodeValue(member)
+		if err != nil {
+			return fmt.Errorf("%q: %w", key, err)
+		}
+		patch[key] = value
+	}
+	*p = patch
+	return nil
+}
+
+// isUnset reports whether raw is the JSON form of Unset.
+func isUnset(raw json.RawMessage) bool {
+	var compact bytes.Buffer
+	if json.Compact(&compact, raw) != nil {
+		return false
+	}
+	return bytes.Equal(compact.Bytes(), unsetJSON)
+}
+
+// Apply returns a copy of data with the patch applied. Paths are applied
+// shortest first, so a patch may replace an object and set keys inside it.
+// Deleting a path that does not exist does nothing; setting one creates the
+// objects leading to it. It fails with ErrInvalidPath if a path is malformed
+// or runs through a value that is not an object, or an array element that
+// does not exist.
+func (p Patch) Apply(data map[string]interface{}) (map[string]interface{}, error) {
+	result := CopyData(data)
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+
+	paths := make([][]string, 0, len(p))
+	keys := map[int]string{}
+	for key := range p {
+		path, err := ParsePath(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[len(paths)] = key
+		paths = append(paths, path)
+	}
+	order := make([]int, len(paths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if len(paths[order[a]]) != len(paths[order[b]]) {
+			return len(paths[order[a]]) < len(paths[order[b]])
+		}
+		return keys[order[a]] < keys[order[b]]
+	})
+
+	for _, i := range order {
+		value := p[keys[i]]
+		var err error
+		if value == Unset {
+			err = unsetPath(result, paths[i])
+		} else {
+			err = setPath(result, paths[i], CopyValue(value))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Diff returns the patch that turns from into to. Objects present in both
+// are compared key by key and arrays of the same length element by element,
+// so the patch addresses the nested values that changed.
+func Diff(from, to map[string]interface{}) Patch {
+	patch := Patch{}
+	diffObjects(patch, nil, from, to)
+	return patch
+}
+
+func diffObjects(patch Patch, prefix []string, from, to map[string]interface{}) {
+	for key, value := range to {
+		path := append(prefix[:len(prefix):len(prefix)], key)
+		if old, ok := from[key]; ok {
+			diffValues(patch, path, old, value)
+		} else {
+			patch[JoinPath(path...)] = CopyValue(value)
+		}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			patch[JoinPath(append(prefix[:len(prefix):len(prefix)], key)...)] = Unset
+		}
+	}
+}
+
+func diffValues(patch Patch, path []string, from, to interface{}) {
+	if EqualValues(from, to) {
+		return
+	}
+	switch to := to.(type) {
+	case map[string]interface{}:
+		if from, ok := from.(map[string]interface{}); ok && len(from) > 0 && len(to) > 0 {
+			diffObjects(patch, path, from, to)
+			return
+		}
+	case []interface{}:
+		if from, ok := from.([]interface{}); ok && len(from) == len(to) {
+			for i := range to {
+				diffValues(patch, append(path[:len(path):len(path)], strconv.Itoa(i)), from[i], to[i])
+			}
+			return
+		}
+	}
+	patch[JoinPath(path...)] = CopyValue(to)
+}
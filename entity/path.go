@@ -0,0 +1,214 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Nested values are addressed by dot separated paths such as
+// contacts.primary.phone. A segment indexes an array when the value it is
+// applied to is one, as in locations.0.city. A literal dot or backslash in a
+// key is escaped with a backslash, and an empty key is written as the segment
+// \e, so {"a": {"": 1}} is addressed as a.\e.
+
+var ErrInvalidPath = errors.New("invalid path")
+
+// emptySegment is how JoinPath writes an empty key.
+const emptySegment = `\e`
+
+// ParsePath splits a path into its unescaped segments.
+func ParsePath(path string) ([]string, error) {
+	var segments []string
+	var segment strings.Builder
+	empty := false // the segment so far is \e
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if empty && c != '.' {
+			return nil, fmt.Errorf("%w: %q: \\e must be a whole segment", ErrInvalidPath, path)
+		}
+		switch {
+		case c == '\\' && i+1 < len(path) && (path[i+1] == '.' || path[i+1] == '\\'):
+			segment.WriteByte(path[i+1])
+			i++
+		case c == '\\' && i+1 < len(path) && path[i+1] == 'e' && segment.Len() == 0:
+			empty = true
+			i++
+		case c == '\\':
+			return nil, fmt.Errorf("%w: %q has a dangling escape", ErrInvalidPath, path)
+		case c == '.':
+			if segment.Len() == 0 && !empty {
+				return nil, fmt.Errorf("%w: %q has an empty segment; write an empty key as \\e", ErrInvalidPath, path)
+			}
+			segments = append(segments, segment.String())
+			segment.Reset()
+			empty = false
+		default:
+			segment.WriteByte(c)
+		}
+	}
+	if segment.Len() == 0 && !empty {
+		return nil, fmt.Errorf("%w: %q has an empty segment; write an empty key as \\e", ErrInvalidPath, path)
+	}
+	return append(segments, segment.String()), nil
+}
+
+// JoinPath escapes segments and joins them into a path.
+func JoinPath(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		if segment == "" {
+			escaped[i] = emptySegment
+			continue
+		}
+		segment = strings.ReplaceAll(segment, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(segment, ".", `\.`)
+	}
+	return strings.Join(escaped, ".")
+}
+
+// Lookup returns the value at path in data and whether it exists.
+func Lookup(data map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = data
+	for _, segment := range path {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			value, ok := container[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, ok := arrayIndex(container, segment)
+			if !ok {
+				return nil, false
+			}
+			current = container[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath sets the value at path in data, creating missing objects along
+// the way. data must not be shared, as it is changed in place.
+func setPath(data map[string]interface{}, path []string, value interface{}) error {
+	parent, last, err := walkToParent(data, path, true)
+	if err != nil {
+		return err
+	}
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[last] = value
+	case []interface{}:
+		index, ok := arrayIndex(container, last)
+		if !ok {
+			return fmt.Errorf("%w: %s: no element %s", ErrInvalidPath, JoinPath(path...), last)
+		}
+		container[index] = value
+	}
+	return nil
+}
+
+// unsetPath removes the value at path from data, if it exists. Removing an
+// array element shifts the ones after it. data must not be shared.
+func unsetPath(data map[string]interface{}, path []string) error {
+	parent, last, err := walkToParent(data, path, false)
+	if err != nil || parent == nil {
+		return err
+	}
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		delete(container, last)
+	case []interface{}:
+		index, ok := arrayIndex(container, last)
+		if !ok {
+			return nil
+		}
+		// the array is rebuilt, so its new value replaces it in the parent
+		shortened := append(append([]interface{}{}, container[:index]...), container[index+1:]...)
+		return setPath(data, path[:len(path)-1], shortened)
+	}
+	return nil
+}
+
+// walkToParent returns the container holding the last segment of path. With
+// create, missing objects are added; otherwise a nil container is returned
+// when part of the path does not exist.
+func walkToParent(data map[string]interface{}, path []string, create bool) (interface{}, string, error) {
+	var current interface{} = data
+	for i, segment := range path[:len(path)-1] {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			next, ok := container[segment]
+			if !ok || next == nil {
+				if !create {
+					return nil, "", nil
+				}
+				next = map[string]interface{}{}
+				container[segment] = next
+			}
+			current = next
+		case []interface{}:
+			index, ok := arrayIndex(container, segment)
+			if !ok {
+				if !create {
+					return nil, "", nil
+				}
+				return nil, "", fmt.Errorf("%w: %s: no element %s", ErrInvalidPath, JoinPath(path[:i+1]...), segment)
+			}
+			current = container[index]
+		default:
+			if !create {
+				return nil, "", nil
+			}
+			return nil, "", fmt.Errorf("%w: %s is not an object or array", ErrInvalidPath, JoinPath(path[:i]...))
+		}
+	}
+	switch current.(type) {
+	case map[string]interface{}, []interface{}:
+		return current, path[len(path)-1], nil
+	}
+	if !create {
+		return nil, "", nil
+	}
+	return nil, "", fmt.Errorf("%w: %s is not an object or array", ErrInvalidPath, JoinPath(path[:len(path)-1]...))
+}
+
+// arrayIndex parses segment as an index into array.
+func arrayIndex(array []interface{}, segment string) (int, bool) {
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index >= len(array) || strconv.Itoa(index) != segment {
+		return 0, false
+	}
+	return index, true
+}
+
+// CopyValue returns a deep copy of a record value.
+func CopyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return CopyData(value)
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, element := range value {
+			copied[i] = CopyValue(element)
+		}
+		return copied
+	}
+	return value
+}
+
+// CopyData returns a deep copy of record data.
+func CopyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = CopyValue(value)
+	}
+	return copied
+}
@@ -0,0 +1,57 @@
+package entity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPathRoundTrip(t *testing.T) {
+	for _, segments := range [][]string{
+		{"a"},
+		{""},
+		{"a", ""},
+		{"", "b"},
+		{"", ""},
+		{"a.b", `c\d`},
+		{`\e`},
+		{"e", "0"},
+	} {
+		path := JoinPath(segments...)
+		parsed, err := ParsePath(path)
+		if err != nil {
+			t.Errorf("ParsePath(%q) of %q: %v", path, segments, err)
+			continue
+		}
+		if !reflect.DeepEqual(parsed, segments) {
+			t.Errorf("ParsePath(JoinPath(%q)) = %q", segments, parsed)
+		}
+	}
+}
+
+func TestParsePathRejectsMalformed(t *testing.T) {
+	for _, path := range []string{"", "a.", ".a", "a..b", `a\eb`, `\ea`, `a\`, `a\x`} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ParsePath(%q) = %v, want ErrInvalidPath", path, err)
+		}
+	}
+}
+
+func TestDiffApplyEmptyKeys(t *testing.T) {
+	for _, step := range []struct{ from, to map[string]interface{} }{
+		{map[string]interface{}{}, map[string]interface{}{"": "x"}},
+		{map[string]interface{}{"": "x"}, map[string]interface{}{"": "y", "a": map[string]interface{}{"": "z"}}},
+		{map[string]interface{}{"a": map[string]interface{}{"": "z", "b": "c"}}, map[string]interface{}{"a": map[string]interface{}{"b": "c"}}},
+		{map[string]interface{}{"": map[string]interface{}{"": "x"}}, map[string]interface{}{"": map[string]interface{}{"": "y"}}},
+	} {
+		patch := Diff(step.from, step.to)
+		applied, err := patch.Apply(step.from)
+		if err != nil {
+			t.Errorf("Apply(%v) to %v: %v", patch, step.from, err)
+			continue
+		}
+		if !EqualValues(applied, step.to) {
+			t.Errorf("Apply(Diff(%v, %v)) = %v", step.from, step.to, applied)
+		}
+	}
+}
@@ -5,27 +5,63 @@ import (
 	"time"
 )
 
+// Operations recorded on each stored version.
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationReplace = "replace"
+	OperationRevert  = "revert"
+
+	// OperationCorrection is a retroactive change inserted at an earlier
+	// effective time; OperationReplay marks the later versions recomputed on
+	// top of it.
+	OperationCorrection = "correction"
+	OperationReplay     = "replay"
+
+	// OperationMerge stores the combined data of a record that another
+	// record was merged into.
+	OperationMerge = "merge"
+
+	// OperationSplit marks both the first version of a record split off
+	// another and the version of the source the split keys moved out of.
+	OperationSplit = "split"
+)
+
+// MaxRecordID is the largest id a record can be written under, the largest
+// integer a JSON number holds exactly in most clients.
+const MaxRecordID = 1<<53 - 1
+
 type Record struct {
-	ID        int               `json:"id"`
-	Data      map[string]string `json:"data"`
-	CreatedAt time.Time         `json:"created_at"`
-	DeletedAt time.Time         `json:"deleted_at"`
+	ID            int64                  `json:"id"`
+	Version       int                    `json:"version,omitempty"`
+	Seq           int64                  `json:"seq,omitempty"`
+	Data          map[string]interface{} `json:"data"` // see DecodeValue for the types values take
+	CreatedAt     time.Time              `json:"created_at"`
+	DeletedAt     time.Time              `json:"deleted_at"`
+	Operation     string                 `json:"operation,omitempty"`
+	SourceVersion int                    `json:"source_version,omitempty"` // the version this one was derived from, e.g. a revert target
+	EffectiveAt   time.Time              `json:"effective_at"`             // when the data became true, as opposed to when it was recorded
+	SupersededAt  *time.Time             `json:"superseded_at,omitempty"`  // when a correction replaced this version with a replayed one
+	Patch         Patch                  `json:"patch,omitempty"`          // the change from the previous effective version
+	Author        string                 `json:"author,omitempty"`         // who wrote the version, when known
+	Hash          string                 `json:"hash,omitempty"`           // see ComputeHash
+	PrevHash      string                 `json:"prev_hash,omitempty"`      // the hash of the version of this record stored before it
+	Upgrade       *SchemaUpgrade         `json:"upgrade,omitempty"`        // set when Data was migrated to a later schema on read
 }
 
 func (d *Record) Copy() Record {
-	values := d.Data
-
-	newMap := map[string]string{}
-	for key, value := range values {
-		newMap[key] = value
+	record := *d
+	record.Data = CopyData(d.Data)
+	if record.Data == nil {
+		record.Data = map[string]interface{}{}
 	}
-
-	return Record{
-		ID:        d.ID,
-		Data:      newMap,
-		CreatedAt: d.CreatedAt,
-		DeletedAt: d.DeletedAt,
+	if d.Patch != nil {
+		record.Patch = Patch{}
+		for key, value := range d.Patch {
+			record.Patch[key] = CopyValue(value)
+		}
 	}
+	return record
 }
 
 func (d *Record) ToString() string {
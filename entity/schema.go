@@ -0,0 +1,44 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RecordType is one version of the JSON Schema that records of a type must
+// match. Registering a changed schema adds a version; earlier versions are
+// kept so old records can be judged by the schema they were written under.
+type RecordType struct {
+	Name      string          `json:"name"`
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	Migration Migration       `json:"migration,omitempty"` // upgrades data from the previous version
+	CreatedAt time.Time       `json:"created_at"`
+	CreatedBy string          `json:"created_by,omitempty"`
+}
+
+// SchemaUpgrade describes how a version read with upgrade was migrated: from
+// the schema version of Type it was written under to the latest one.
+type SchemaUpgrade struct {
+	Type string `json:"type"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// FieldError is a value that does not match its record type's schema. Path
+// uses the same syntax as patch keys.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// Validation is the result of checking a version of a record against the
+// schema version of its type that applied when the version was written.
+type Validation struct {
+	ID            int64        `json:"id"`
+	Version       int          `json:"version"`
+	Type          string       `json:"type,omitempty"`
+	SchemaVersion int          `json:"schema_version,omitempty"`
+	Valid         bool         `json:"valid"`
+	Errors        []FieldError `json:"errors"`
+}
@@ -0,0 +1,27 @@
+package entity
+
+import "time"
+
+// Split records that NewID was created from Keys of version SourceVersion
+// of SourceID. If Moved, the keys were removed from the source at the same
+// time, so their later changes belong to NewID only.
+type Split struct {
+	ID            int       `json:"id"`
+	SourceID      int64     `json:"source_id"`
+	SourceVersion int       `json:"source_version"`
+	NewID         int64     `json:"new_id"`
+	Keys          []string  `json:"keys"`
+	Moved         bool      `json:"moved"`
+	SplitAt       time.Time `json:"split_at"`
+	SplitBy       string    `json:"split_by"`
+}
+
+// HasKey reports whether key was split off.
+func (s *Split) HasKey(key string) bool {
+	for _, splitKey := range s.Keys {
+		if splitKey == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package entity
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Record values are decoded from JSON with their types intact: a string, a
+// json.Number, a bool, nil for null, a map[string]interface{} for an object
+// or an []interface{} for an array. Numbers keep the text they were written
+// with, so large integers and decimals round-trip exactly.
+
+var ErrInvalidValue = errors.New(`{"$unset": true} can only delete a key, not be stored`)
+
+// DecodeValue decodes a single JSON record value.
+func DecodeValue(raw []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if containsUnset(value) {
+		return nil, ErrInvalidValue
+	}
+	return value, nil
+}
+
+// containsUnset reports whether the JSON form of Unset appears anywhere in
+// value.
+func containsUnset(value interface{}) bool {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) == 1 && value["$unset"] == true {
+			return true
+		}
+		for _, member := range value {
+			if containsUnset(member) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, element := range value {
+			if containsUnset(element) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DecodeData decodes a JSON object of record values.
+func DecodeData(raw []byte) (map[string]interface{}, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return nil, err
+	}
+	if members == nil {
+		return nil, nil
+	}
+	data := make(map[string]interface{}, len(members))
+	for key, member := range members {
+		value, err := DecodeValue(member)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", key, err)
+		}
+		data[key] = value
+	}
+	return data, nil
+}
+
+// EqualValues reports whether two record values are the same, type included:
+// the string "1" is not the number 1.
+func EqualValues(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
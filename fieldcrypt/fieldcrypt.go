@@ -0,0 +1,200 @@
+// Package fieldcrypt encrypts individual record fields with AES-GCM.
+//
+// An encrypted value is stored in place of the plaintext as
+//
+//	enc2:<key id>:<base64 of nonce and ciphertext>
+//
+// String values are encrypted as they are. Other JSON values, such as numbers
+// and booleans, are encrypted as their JSON encoding and marked with the
+// "encj2:" prefix instead, so they decrypt to the same type.
+//
+// The tenant, record id and field name are authenticated alongside the
+// ciphertext, so a value copied to another tenant, record or field no longer
+// decrypts. The key id lets old values be read after the active key is
+// rotated.
+//
+// Values written before the tenant was bound carry the "enc:" and "encj:"
+// prefixes and authenticate only the record id and field. They still open,
+// but Bound reports them so they can be encrypted again.
+package fieldcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	prefix     = "enc2:"
+	jsonPrefix = "encj2:"
+
+	legacyPrefix     = "enc:"
+	legacyJSONPrefix = "encj:"
+)
+
+var ErrUnknownKey = errors.New("value was encrypted with an unknown key")
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+// Keyring holds the encryption keys and the fields they protect.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+	fields map[string]bool
+}
+
+// New returns a keyring that encrypts fields with the key named active. keys
+// maps key ids to 256-bit AES keys; ids must not contain ':'.
+func New(active string, keys map[string][]byte, fields []string) (*Keyring, error) {
+	k := &Keyring{active: active, keys: map[string]cipher.AEAD{}, fields: map[string]bool{}}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %s: must be 32 bytes, got %d", id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := k.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %q is not configured", active)
+	}
+	for _, field := range fields {
+		k.fields[field] = true
+	}
+	return k, nil
+}
+
+// ActiveKey returns the id of the key new values are encrypted with.
+func (k *Keyring) ActiveKey() string {
+	return k.active
+}
+
+// Sensitive reports whether field is encrypted.
+func (k *Keyring) Sensitive(field string) bool {
+	return k.fields[field]
+}
+
+// Seal encrypts the value of field in record id of tenant with the active
+// key. value is a string or any other JSON value.
+func (k *Keyring) Seal(tenant string, id int64, field string, value interface{}) (string, error) {
+	envelope, plaintext := prefix, []byte(nil)
+	if text, ok := value.(string); ok {
+		plaintext = []byte(text)
+	} else {
+		var err error
+		envelope = jsonPrefix
+		plaintext, err = json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, associatedData(tenant, id, field, false))
+	return envelope + k.active + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal for the same tenant, record and
+// field. It returns the value with its original type; numbers as
+// json.Number.
+func (k *Keyring) Open(tenant string, id int64, field string, value interface{}) (interface{}, error) {
+	text, ok := value.(string)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+	keyID, payload, typed, legacy, ok := split(text)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrNotEncrypted
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData(tenant, id, field, legacy))
+	if err != nil {
+		return nil, err
+	}
+	if !typed {
+		return string(plaintext), nil
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(plaintext))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// KeyID returns the id of the key value was encrypted with, if it is
+// encrypted.
+func KeyID(value interface{}) (string, bool) {
+	text, ok := value.(string)
+	if !ok {
+		return "", false
+	}
+	keyID, _, _, _, ok := split(text)
+	return keyID, ok
+}
+
+// Bound reports whether value is encrypted in the current format, which
+// binds it to its tenant.
+func Bound(value interface{}) bool {
+	text, ok := value.(string)
+	if !ok {
+		return false
+	}
+	_, _, _, legacy, ok := split(text)
+	return ok && !legacy
+}
+
+func split(value string) (keyID, payload string, typed, legacy, ok bool) {
+	switch {
+	case strings.HasPrefix(value, prefix):
+		value = value[len(prefix):]
+	case strings.HasPrefix(value, jsonPrefix):
+		value, typed = value[len(jsonPrefix):], true
+	case strings.HasPrefix(value, legacyPrefix):
+		value, legacy = value[len(legacyPrefix):], true
+	case strings.HasPrefix(value, legacyJSONPrefix):
+		value, typed, legacy = value[len(legacyJSONPrefix):], true, true
+	default:
+		return "", "", false, false, false
+	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false, false, false
+	}
+	return parts[0], parts[1], typed, legacy, true
+}
+
+// associatedData is what a value is bound to. The tenant is length-prefixed
+// so no tenant name can run into the id.
+func associatedData(tenant string, id int64, field string, legacy bool) []byte {
+	if legacy {
+		return []byte(strconv.FormatInt(id, 10) + "/" + field)
+	}
+	return []byte(strconv.Itoa(len(tenant)) + ":" + tenant + "/" + strconv.FormatInt(id, 10) + "/" + field)
+}
@@ -1,6 +1,6 @@
 module github.com/temelpa/timetravel
 
-go 1.17
+go 1.20
 
 require (
 	github.com/gorilla/mux v1.8.0
@@ -0,0 +1,206 @@
+// Package jwtauth validates JSON Web Tokens signed with RS256 or EdDSA
+// against keys from a JSON Web Key Set file.
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of a validated token.
+type Claims map[string]interface{}
+
+// Subject returns the sub claim.
+func (c Claims) Subject() string {
+	subject, _ := c["sub"].(string)
+	return subject
+}
+
+// Strings returns the claim at a dot separated path, such as
+// "realm_access.roles", as a list. A string claim is split on spaces, like
+// the OAuth scope claim.
+func (c Claims) Strings(path string) []string {
+	var value interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Verifier validates tokens issued by one issuer for one audience.
+type Verifier struct {
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// NewVerifier returns a verifier trusting keys, which maps key ids to RSA or
+// Ed25519 public keys. leeway is the clock skew tolerated on exp and nbf.
+func NewVerifier(keys map[string]crypto.PublicKey, issuer, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{keys: keys, issuer: issuer, audience: audience, leeway: leeway}
+}
+
+// LooksLikeToken reports whether value has the shape of a compact JWT.
+func LooksLikeToken(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+// Verify checks the token's signature, exp, nbf, iss and aud and returns its
+// claims.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	key, ok := v.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, header.Kid)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("%w: algorithm %q does not match key", ErrInvalidToken, header.Alg)
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case ed25519.PublicKey:
+		if header.Alg != "EdDSA" {
+			return nil, fmt.Errorf("%w: algorithm %q does not match key", ErrInvalidToken, header.Alg)
+		}
+		if !ed25519.Verify(key, signed, signature) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported key", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, v.validate(claims, now)
+}
+
+// validate checks the registered claims.
+func (v *Verifier) validate(claims Claims, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: no exp", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if issuer, _ := claims["iss"].(string); issuer != v.issuer {
+		return fmt.Errorf("%w: issuer %q", ErrInvalidToken, issuer)
+	}
+	audienceOK := false
+	for _, audience := range claims.Strings("aud") {
+		audienceOK = audienceOK || audience == v.audience
+	}
+	if !audienceOK {
+		return fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	if claims.Subject() == "" {
+		return fmt.Errorf("%w: no sub", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	return nil
+}
+
+// LoadJWKS reads the RSA and Ed25519 keys of a JSON Web Key Set file, keyed
+// by kid. Keys of other types are skipped.
+func LoadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		switch {
+		case jwk.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				return nil, fmt.Errorf("%s: key %q: malformed RSA key", path, jwk.Kid)
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%s: key %q: malformed Ed25519 key", path, jwk.Kid)
+			}
+			keys[jwk.Kid] = ed25519.PublicKey(x)
+		}
+	}
+	return keys, nil
+}
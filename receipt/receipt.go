@@ -0,0 +1,163 @@
+// Package receipt issues and verifies signed statements of record state.
+//
+// A receipt carries the statement as JSON alongside a detached Ed25519
+// signature over its compact encoding and the id of the key that made it, so
+// a holder of the published public keys can check it offline and keys can be
+// rotated without invalidating receipts issued earlier.
+package receipt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// Algorithm is the only signature algorithm receipts use.
+const Algorithm = "Ed25519"
+
+var ErrUnknownKey = errors.New("receipt was signed with an unknown key")
+var ErrBadSignature = errors.New("receipt signature does not match its statement")
+var ErrOtherTenant = errors.New("receipt was issued for another tenant")
+
+// Statement is what a receipt attests: the record version of the tenant, and
+// the AS OF view it was read at, if any. Record ids are only unique within a
+// tenant, and one key signs for every tenant, so the tenant is part of what is
+// attested.
+type Statement struct {
+	Tenant   string        `json:"tenant"`
+	Record   entity.Record `json:"record"`
+	AsOf     *time.Time    `json:"as_of,omitempty"`
+	KnownAt  *time.Time    `json:"known_at,omitempty"`
+	IssuedAt time.Time     `json:"issued_at"`
+}
+
+// Receipt is a statement with its detached signature. Signature is the
+// base64 encoded signature of the compact JSON encoding of Statement.
+type Receipt struct {
+	Statement json.RawMessage `json:"statement"`
+	KeyID     string          `json:"key_id"`
+	Algorithm string          `json:"algorithm"`
+	Signature string          `json:"signature"`
+}
+
+// KeySet maps key ids to the public keys receipts are verified with.
+type KeySet map[string]ed25519.PublicKey
+
+// Signer signs statements with one private key.
+type Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+func NewSigner(keyID string, key ed25519.PrivateKey) *Signer {
+	return &Signer{keyID: keyID, key: key}
+}
+
+// KeyID returns the id receipts signed by s carry.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// PublicKey returns the key receipts signed by s verify with.
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign encodes statement and signs it.
+func (s *Signer) Sign(statement Statement) (*Receipt, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &Receipt{
+		Statement: payload,
+		KeyID:     s.keyID,
+		Algorithm: Algorithm,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload)),
+	}, nil
+}
+
+// SignPayload signs payload as it is and returns the base64 encoded
+// signature, for records other than receipts that are signed with the same
+// key.
+func (s *Signer) SignPayload(payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+}
+
+// Verify checks the receipt's signature against the key it names and that it
+// was issued for tenant, and returns the statement it attests. Whitespace in
+// the statement is ignored, so a receipt that was pretty-printed still
+// verifies.
+func Verify(receipt *Receipt, keys KeySet, tenant string) (*Statement, error) {
+	if receipt.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported receipt algorithm %q", receipt.Algorithm)
+	}
+	key, ok := keys[receipt.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, receipt.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(receipt.Signature)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, receipt.Statement); err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(key, payload.Bytes(), signature) {
+		return nil, ErrBadSignature
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(payload.Bytes(), &statement); err != nil {
+		return nil, err
+	}
+	if statement.Tenant != tenant {
+		return nil, fmt.Errorf("%w: %q", ErrOtherTenant, statement.Tenant)
+	}
+	return &statement, nil
+}
+
+// ParsePrivateKey reads a PEM encoded PKCS #8 Ed25519 private key, such as
+// one written by `openssl genpkey -algorithm ed25519`.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+	return private, nil
+}
+
+// ParsePublicKey reads a PEM encoded PKIX Ed25519 public key, such as one
+// written by `openssl pkey -pubout`.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return public, nil
+}
@@ -0,0 +1,429 @@
+// Package schema validates record data against a subset of JSON Schema.
+//
+// The supported keywords are type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum and exclusiveMaximum, along
+// with the annotations title, description, default, examples, $schema, $id
+// and $comment. Any other keyword is rejected when the schema is compiled,
+// so a schema never silently checks less than it appears to.
+//
+// Numbers are compared by value, so 1, 1.0 and 1e0 are the same number.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Schema is a compiled schema for record data.
+type Schema struct {
+	root *node
+}
+
+// node is a compiled schema or subschema. A nil limit is not checked.
+type node struct {
+	never bool // the false schema, which nothing matches
+
+	types    []string
+	enum     []interface{}
+	constant *interface{}
+
+	properties           map[string]*node
+	required             []string
+	additionalProperties *node
+
+	items              *node
+	minItems, maxItems *int
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minimum, maximum                   *big.Rat
+	exclusiveMinimum, exclusiveMaximum *big.Rat
+}
+
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true,
+	"title": true, "description": true, "default": true, "examples": true,
+}
+
+var knownTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+// Compile parses a schema. Record data is always an object, so the schema
+// must accept one.
+func Compile(raw []byte) (*Schema, error) {
+	value, err := entity.DecodeValue(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%w: schema must be a JSON object", ErrInvalidSchema)
+	}
+	root, err := compile(value, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(root.types) > 0 && !contains(root.types, "object") {
+		return nil, fmt.Errorf("%w: records are objects, so the schema must accept type object", ErrInvalidSchema)
+	}
+	return &Schema{root: root}, nil
+}
+
+// compile compiles the schema at location, a JSON pointer used in errors.
+func compile(value interface{}, location string) (*node, error) {
+	switch value := value.(type) {
+	case bool:
+		return &node{never: !value}, nil
+	case map[string]interface{}:
+		n := &node{}
+		for keyword, argument := range value {
+			if annotations[keyword] {
+				continue
+			}
+			if err := n.compileKeyword(keyword, argument, location); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: %s must be an object or boolean", ErrInvalidSchema, describe(location))
+}
+
+func (n *node) compileKeyword(keyword string, argument interface{}, location string) error {
+	at := location + "/" + keyword
+	invalid := func(format string) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalidSchema, describe(at), format)
+	}
+
+	var err error
+	switch keyword {
+	case "type":
+		switch argument := argument.(type) {
+		case string:
+			n.types = []string{argument}
+		case []interface{}:
+			for _, t := range argument {
+				name, ok := t.(string)
+				if !ok {
+					return invalid("must be a type name or an array of them")
+				}
+				n.types = append(n.types, name)
+			}
+		default:
+			return invalid("must be a type name or an array of them")
+		}
+		for _, t := range n.types {
+			if !knownTypes[t] {
+				return invalid(fmt.Sprintf("names unknown type %q", t))
+			}
+		}
+	case "enum":
+		values, ok := argument.([]interface{})
+		if !ok || len(values) == 0 {
+			return invalid("must be a non-empty array")
+		}
+		n.enum = values
+	case "const":
+		n.constant = &argument
+	case "properties":
+		members, ok := argument.(map[string]interface{})
+		if !ok {
+			return invalid("must be an object")
+		}
+		n.properties = map[string]*node{}
+		for name, member := range members {
+			n.properties[name], err = compile(member, at+"/"+name)
+			if err != nil {
+				return err
+			}
+		}
+	case "required":
+		names, ok := argument.([]interface{})
+		if !ok {
+			return invalid("must be an array of property names")
+		}
+		for _, name := range names {
+			name, ok := name.(string)
+			if !ok {
+				return invalid("must be an array of property names")
+			}
+			n.required = append(n.required, name)
+		}
+	case "additionalProperties":
+		n.additionalProperties, err = compile(argument, at)
+	case "items":
+		n.items, err = compile(argument, at)
+	case "minItems":
+		n.minItems, err = count(argument, at)
+	case "maxItems":
+		n.maxItems, err = count(argument, at)
+	case "minLength":
+		n.minLength, err = count(argument, at)
+	case "maxLength":
+		n.maxLength, err = count(argument, at)
+	case "pattern":
+		pattern, ok := argument.(string)
+		if !ok {
+			return invalid("must be a regular expression")
+		}
+		n.pattern, err = regexp.Compile(pattern)
+		if err != nil {
+			return invalid(fmt.Sprintf("is not a valid regular expression: %v", err))
+		}
+	case "minimum":
+		n.minimum, err = limit(argument, at)
+	case "maximum":
+		n.maximum, err = limit(argument, at)
+	case "exclusiveMinimum":
+		n.exclusiveMinimum, err = limit(argument, at)
+	case "exclusiveMaximum":
+		n.exclusiveMaximum, err = limit(argument, at)
+	default:
+		return fmt.Errorf("%w: unsupported keyword %q in %s", ErrInvalidSchema, keyword, describe(location))
+	}
+	return err
+}
+
+// count parses a non-negative integer keyword argument.
+func count(argument interface{}, location string) (*int, error) {
+	value, ok := number(argument)
+	if !ok || !value.IsInt() || value.Sign() < 0 || !value.Num().IsInt64() {
+		return nil, fmt.Errorf("%w: %s must be a non-negative integer", ErrInvalidSchema, describe(location))
+	}
+	n := int(value.Num().Int64())
+	return &n, nil
+}
+
+// limit parses a numeric keyword argument.
+func limit(argument interface{}, location string) (*big.Rat, error) {
+	value, ok := number(argument)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidSchema, describe(location))
+	}
+	return value, nil
+}
+
+func describe(location string) string {
+	if location == "" {
+		return "the schema"
+	}
+	return location
+}
+
+// Validate checks data against the schema and returns every violation,
+// sorted by path. The paths use the same syntax as patch keys.
+func (s *Schema) Validate(data map[string]interface{}) []entity.FieldError {
+	errs := []entity.FieldError{}
+	s.root.validate(data, nil, &errs)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+func (n *node) validate(value interface{}, path []string, errs *[]entity.FieldError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, entity.FieldError{Path: entity.JoinPath(path...), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n.never {
+		fail("is not allowed")
+		return
+	}
+	if len(n.types) > 0 && !n.hasType(value) {
+		fail("must be of type %s", typeList(n.types))
+		return
+	}
+	if n.enum != nil && !containsValue(n.enum, value) {
+		fail("must be one of the allowed values")
+	}
+	if n.constant != nil && !equal(*n.constant, value) {
+		fail("must equal the constant value")
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		n.validateObject(value, path, errs)
+	case []interface{}:
+		if n.minItems != nil && len(value) < *n.minItems {
+			fail("must have at least %d items", *n.minItems)
+		}
+		if n.maxItems != nil && len(value) > *n.maxItems {
+			fail("must have at most %d items", *n.maxItems)
+		}
+		if n.items != nil {
+			for i, item := range value {
+				n.items.validate(item, append(path[:len(path):len(path)], fmt.Sprint(i)), errs)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if n.minLength != nil && length < *n.minLength {
+			fail("must be at least %d characters long", *n.minLength)
+		}
+		if n.maxLength != nil && length > *n.maxLength {
+			fail("must be at most %d characters long", *n.maxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(value) {
+			fail("must match the pattern %s", n.pattern)
+		}
+	default:
+		if x, ok := number(value); ok {
+			if n.minimum != nil && x.Cmp(n.minimum) < 0 {
+				fail("must be at least %s", n.minimum.RatString())
+			}
+			if n.maximum != nil && x.Cmp(n.maximum) > 0 {
+				fail("must be at most %s", n.maximum.RatString())
+			}
+			if n.exclusiveMinimum != nil && x.Cmp(n.exclusiveMinimum) <= 0 {
+				fail("must be greater than %s", n.exclusiveMinimum.RatString())
+			}
+			if n.exclusiveMaximum != nil && x.Cmp(n.exclusiveMaximum) >= 0 {
+				fail("must be less than %s", n.exclusiveMaximum.RatString())
+			}
+		}
+	}
+}
+
+func (n *node) validateObject(object map[string]interface{}, path []string, errs *[]entity.FieldError) {
+	child := func(name string) []string {
+		return append(path[:len(path):len(path)], name)
+	}
+	for _, name := range n.required {
+		if _, ok := object[name]; !ok {
+			*errs = append(*errs, entity.FieldError{Path: entity.JoinPath(child(name)...), Message: "is required"})
+		}
+	}
+	for name, value := range object {
+		if property, ok := n.properties[name]; ok {
+			property.validate(value, child(name), errs)
+		} else if n.additionalProperties != nil {
+			n.additionalProperties.validate(value, child(name), errs)
+		}
+	}
+}
+
+func (n *node) hasType(value interface{}) bool {
+	for _, t := range n.types {
+		switch t {
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		case "number":
+			if _, ok := number(value); ok {
+				return true
+			}
+		case "integer":
+			if x, ok := number(value); ok && x.IsInt() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeList(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	list := ""
+	for i, t := range types {
+		switch {
+		case i == len(types)-1:
+			list += " or "
+		case i > 0:
+			list += ", "
+		}
+		list += t
+	}
+	return list
+}
+
+// number returns the value of a JSON number as decoded by entity.DecodeValue.
+func number(value interface{}) (*big.Rat, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(string(value))
+	case float64:
+		return new(big.Rat).SetFloat64(value), true
+	}
+	return nil, false
+}
+
+// equal compares JSON values, numbers by value.
+func equal(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x.Cmp(y) == 0
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, ok := b[key]; !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return entity.EqualValues(a, b)
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
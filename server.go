@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/temelpa/timetravel/api"
+	"github.com/temelpa/timetravel/config"
 	"github.com/temelpa/timetravel/service"
 	"github.com/temelpa/timetravel/storage"
 )
@@ -20,15 +24,64 @@ func logError(err error) {
 }
 
 func main() {
-	router := mux.NewRouter()
-	db, err := storage.NewStorage()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			err := command(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+	err := serve(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+// serve runs the HTTP server. It is the default when no command is given.
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dbPath := flags.String("db", "sqlite-database.db", "path to the SQLite database")
+	configPath := flags.String("config", "timetravel.json", "path to the optional JSON configuration file")
+	address := flags.String("addr", "127.0.0.1:8000", "address to listen on")
+	snapshotInterval := flags.Int("snapshot-interval", storage.DefaultSnapshotInterval, "versions stored as patches between full snapshots")
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+
+	router := mux.NewRouter()
+	db, err := storage.NewStorageAt(*dbPath)
+	if err != nil {
+		return err
+	}
+	db.SetSnapshotInterval(*snapshotInterval)
 	memService := service.NewInMemoryRecordService()
 	dbService := service.NewDatabaseService(db)
+	signer, receiptKeys, err := service.LoadReceiptKeys(cfg.Signing)
+	if err != nil {
+		return err
+	}
+	dbService.SetReceiptKeys(signer, receiptKeys)
+	fields, err := service.LoadEncryptionKeys(cfg.Encryption)
+	if err != nil {
+		return err
+	}
+	dbService.SetEncryption(fields)
+	err = dbService.SetTokenAuth(cfg.JWT)
+	if err != nil {
+		return err
+	}
+	dbService.SetAccessPolicies(cfg.Authorization.Policies)
 	api := api.NewAPI(&memService, dbService)
 
+	if len(cfg.Retention.Policies) > 0 {
+		go service.NewCompactor(&dbService, cfg.Retention).Run(context.Background())
+	}
+
 	apiRoute := router.PathPrefix("/api/v1").Subrouter()
 	apiRouteV2 := router.PathPrefix("/api/v2").Subrouter()
 	apiRoute.Path("/health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -38,14 +91,13 @@ func main() {
 	api.CreateRoutes(apiRoute)
 	api.CreateRoutesV2(apiRouteV2)
 
-	address := "127.0.0.1:8000"
 	srv := &http.Server{
 		Handler:      router,
-		Addr:         address,
+		Addr:         *address,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
 
-	log.Printf("listening on %s", address)
-	log.Fatal(srv.ListenAndServe())
+	log.Printf("listening on %s", *address)
+	return srv.ListenAndServe()
 }
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"context"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// LogAccess records in the access log that the caller read entry.RecordID.
+func (s *DatabaseService) LogAccess(ctx context.Context, entry entity.AccessEntry) error {
+	entry.Actor = ActorFromContext(ctx)
+	return s.store(ctx).AppendAccess(entry)
+}
+
+// GetAccessEntries returns the access log entries matching filter.
+func (s *DatabaseService) GetAccessEntries(ctx context.Context, filter storage.AccessFilter) ([]entity.AccessEntry, error) {
+	return s.store(ctx).GetAccessEntries(filter)
+}
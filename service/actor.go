@@ -0,0 +1,28 @@
+package service
+
+import "context"
+
+type actorKey struct{}
+
+// anonymousActor is recorded when a change is made without a known caller.
+const anonymousActor = "anonymous"
+
+// WithActor returns a context that attributes changes to actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns who is making the request, or "anonymous".
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// authorFromContext returns who is making the request, or "" when unknown,
+// for recording as the author of new versions.
+func authorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrInvalidAlias = errors.New("alias namespaces may only contain letters, digits, '-' and '_', and keys must be 1 to 256 characters without '/'")
+var ErrAliasTaken = errors.New("alias already names a record at or after that time")
+var ErrAliasNotActive = errors.New("alias does not currently name the record")
+var ErrAliasNotFound = errors.New("alias does not name a record")
+
+// aliasNamespace matches namespace names, which follow type names.
+var aliasNamespace = typeName
+
+// aliasError translates storage alias errors into service errors.
+func aliasError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrAliasTaken):
+		return ErrAliasTaken
+	case errors.Is(err, storage.ErrAliasNotActive):
+		return ErrAliasNotActive
+	case errors.Is(err, storage.ErrAliasNotFound):
+		return ErrAliasNotFound
+	}
+	return err
+}
+
+// validAlias reports whether namespace and key can be written as
+// "namespace:key" in a path.
+func validAlias(namespace, key string) bool {
+	return aliasNamespace.MatchString(namespace) && key != "" && len(key) <= 256 &&
+		!strings.ContainsAny(key, "/\x00")
+}
+
+// AddAlias gives a record an external key, such as a policy number, from
+// validFrom on, or from now if validFrom is zero. Within a namespace a key
+// names at most one record at any time, so a key can only move to another
+// record once it was removed from the first.
+func (s *DatabaseService) AddAlias(ctx context.Context, recordID int64, namespace, key string, validFrom time.Time) (entity.Alias, error) {
+	if !validAlias(namespace, key) {
+		return entity.Alias{}, ErrInvalidAlias
+	}
+	if validFrom.IsZero() {
+		validFrom = time.Now()
+	}
+	if validFrom.After(time.Now()) {
+		return entity.Alias{}, ErrEffectiveTimeInFuture
+	}
+	if _, err := s.GetLastestRecordByID(ctx, recordID); err != nil {
+		return entity.Alias{}, err
+	}
+	alias, err := s.store(ctx).AddAlias(entity.Alias{
+		Namespace:  namespace,
+		Key:        key,
+		RecordID:   recordID,
+		ValidFrom:  validFrom,
+		AssignedBy: ActorFromContext(ctx),
+	})
+	if err != nil {
+		return entity.Alias{}, aliasError(err)
+	}
+	return *alias, nil
+}
+
+// RemoveAlias ends an alias of a record now. What it resolved to before
+// stays on record.
+func (s *DatabaseService) RemoveAlias(ctx context.Context, recordID int64, namespace, key string) (entity.Alias, error) {
+	alias, err := s.store(ctx).RemoveAlias(recordID, namespace, key, ActorFromContext(ctx))
+	if err != nil {
+		return entity.Alias{}, aliasError(err)
+	}
+	return *alias, nil
+}
+
+// GetAliases lists every alias a record has had, removed ones included.
+func (s *DatabaseService) GetAliases(ctx context.Context, recordID int64) ([]entity.Alias, error) {
+	return s.store(ctx).GetAliases(recordID)
+}
+
+// ResolveAlias returns the id of the record namespace:key named at the given
+// time.
+func (s *DatabaseService) ResolveAlias(ctx context.Context, namespace, key string, at time.Time) (int64, error) {
+	if !validAlias(namespace, key) {
+		return 0, ErrInvalidAlias
+	}
+	id, err := s.store(ctx).ResolveAlias(namespace, key, at)
+	return id, aliasError(err)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/jwtauth"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// Scopes an API key can carry. ScopeAdmin grants all of them.
+const (
+	ScopeRead    = "records:read"
+	ScopeWrite   = "records:write"
+	ScopeDecrypt = "records:decrypt"
+	ScopeAdmin   = entity.ScopeAdmin
+)
+
+var knownScopes = map[string]bool{ScopeRead: true, ScopeWrite: true, ScopeDecrypt: true, ScopeAdmin: true}
+
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+var ErrKeyNotActive = errors.New("api key does not exist or was already revoked")
+var ErrKeyIncomplete = errors.New("an api key needs a name and at least one known scope")
+
+// apiKeyPrefix starts every key so leaked keys are easy to recognize.
+const apiKeyPrefix = "tt_"
+
+type principalKey struct{}
+
+// WithPrincipal returns a context for a request made by principal. Changes
+// are attributed to its subject and it only sees the records of its tenant.
+func WithPrincipal(ctx context.Context, principal entity.Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	ctx = WithTenant(ctx, principal.Tenant)
+	return WithActor(ctx, principal.Subject)
+}
+
+// PrincipalFromContext returns the authenticated caller, if any.
+func PrincipalFromContext(ctx context.Context) (entity.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(entity.Principal)
+	return principal, ok
+}
+
+// IssueAPIKey creates a key of the caller's tenant with the given scopes and
+// roles. The returned key
+// is the only time it is available in full; only its hash is stored.
+func (s *DatabaseService) IssueAPIKey(ctx context.Context, name string, scopes, roles []string) (string, entity.APIKey, error) {
+	if name == "" || len(scopes) == 0 {
+		return "", entity.APIKey{}, ErrKeyIncomplete
+	}
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return "", entity.APIKey{}, fmt.Errorf("%w: unknown scope %q", ErrKeyIncomplete, scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", entity.APIKey{}, err
+	}
+	token := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	key, err := s.store(ctx).CreateAPIKey(name, token[:len(apiKeyPrefix)+8], hashAPIKey(token), scopes, roles, ActorFromContext(ctx))
+	if err != nil {
+		return "", entity.APIKey{}, err
+	}
+	return token, *key, nil
+}
+
+// GetAPIKeys lists every key issued to the caller's tenant, revoked ones
+// included.
+func (s *DatabaseService) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	return s.store(ctx).GetAPIKeys()
+}
+
+// RevokeAPIKey stops a key from authenticating.
+func (s *DatabaseService) RevokeAPIKey(ctx context.Context, keyID int) (entity.APIKey, error) {
+	key, err := s.store(ctx).RevokeAPIKey(keyID, ActorFromContext(ctx))
+	if errors.Is(err, storage.ErrKeyNotActive) {
+		return entity.APIKey{}, ErrKeyNotActive
+	}
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	return *key, nil
+}
+
+// Authenticate returns the principal behind a bearer token or API key. It
+// fails with ErrUnauthenticated for invalid tokens and for unknown and
+// revoked keys.
+func (s *DatabaseService) Authenticate(ctx context.Context, token string) (entity.Principal, error) {
+	if token == "" {
+		return entity.Principal{}, ErrUnauthenticated
+	}
+	if s.tokens != nil && jwtauth.LooksLikeToken(token) {
+		principal, err := s.tokens.authenticateToken(token)
+		if err != nil {
+			log.Printf("rejected token: %v", err)
+			return entity.Principal{}, ErrUnauthenticated
+		}
+		return principal, nil
+	}
+
+	key, err := s.storage.GetActiveAPIKey(hashAPIKey(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Principal{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return entity.Principal{}, err
+	}
+	return entity.Principal{Subject: "apikey:" + key.Name, Tenant: key.Tenant, Roles: key.Roles, Scopes: key.Scopes}, nil
+}
+
+// hashAPIKey hashes a key for storage. Keys are long random strings, so a
+// plain SHA-256 is enough to make a leaked table useless.
+func hashAPIKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
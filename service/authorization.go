@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/entity"
+)
+
+// Operations checked against access policies.
+const (
+	AccessRead   = "read"
+	AccessWrite  = "write"
+	AccessDelete = "delete"
+)
+
+var ErrForbidden = errors.New("access to this record is not permitted")
+
+// SetAccessPolicies restricts which records callers may access. Without
+// policies every record is accessible.
+func (s *DatabaseService) SetAccessPolicies(policies []config.AccessPolicy) {
+	s.policies = policies
+}
+
+// Authorize checks that the caller may perform operation on record id. It
+// allows callers with the admin scope, requests made without a principal,
+// such as from the command line, and everything when no policies are set.
+// Denials are written to the audit trail and return ErrForbidden.
+func (s *DatabaseService) Authorize(ctx context.Context, operation string, id int64) error {
+	principal, ok := PrincipalFromContext(ctx)
+	if len(s.policies) == 0 || !ok || principal.HasScope(ScopeAdmin) {
+		return nil
+	}
+
+	var tags map[string]bool
+	for _, policy := range s.policies {
+		if !policyMatches(policy, principal, operation, id) {
+			continue
+		}
+		if policy.Tag != "" {
+			if tags == nil {
+				recordTags, err := s.store(ctx).GetRecordTags(id)
+				if err != nil {
+					return err
+				}
+				tags = map[string]bool{}
+				for _, tag := range recordTags {
+					tags[tag] = true
+				}
+			}
+			if !tags[policy.Tag] {
+				continue
+			}
+		}
+		return nil
+	}
+
+	err := s.store(ctx).AppendAudit(entity.AuditEntry{
+		Actor:    principal.Subject,
+		Action:   "access.deny",
+		RecordID: id,
+		Detail:   fmt.Sprintf("%s denied to roles %s", operation, strings.Join(principal.Roles, " ")),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return ErrForbidden
+}
+
+// policyMatches checks everything about a policy but its tag.
+func policyMatches(policy config.AccessPolicy, principal entity.Principal, operation string, id int64) bool {
+	if policy.MinID > 0 && id < policy.MinID || policy.MaxID > 0 && id > policy.MaxID {
+		return false
+	}
+	if len(policy.Operations) > 0 && !contains(policy.Operations, operation) {
+		return false
+	}
+	if len(policy.Roles) == 0 {
+		return true
+	}
+	for _, role := range principal.Roles {
+		if contains(policy.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// VerifyChain checks the hash chain of a record's versions, or of every
+// record when id is 0, and reports the first broken link.
+func (s *DatabaseService) VerifyChain(ctx context.Context, id int64) (entity.ChainReport, error) {
+	if id < 0 {
+		return entity.ChainReport{}, ErrRecordIDInvalid
+	}
+	report, err := s.store(ctx).VerifyChain(id)
+	if err != nil {
+		return entity.ChainReport{}, err
+	}
+	if id > 0 && report.Checked == 0 {
+		return entity.ChainReport{}, ErrRecordDoesNotExist
+	}
+	return *report, nil
+}
+
+// GetTenants returns every tenant that has records, in alphabetical order.
+func (s *DatabaseService) GetTenants(ctx context.Context) ([]string, error) {
+	return s.storage.GetTenants()
+}
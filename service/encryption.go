@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/fieldcrypt"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// canDecrypt reports whether the caller may read encrypted fields in
+// plaintext. Other callers see them as stored.
+func canDecrypt(ctx context.Context) bool {
+	principal, ok := PrincipalFromContext(ctx)
+	return ok && principal.HasScope(ScopeDecrypt)
+}
+
+// LoadEncryptionKeys reads the keys named in cfg. The keyring is nil when no
+// fields are encrypted.
+func LoadEncryptionKeys(cfg config.EncryptionConfig) (*fieldcrypt.Keyring, error) {
+	if len(cfg.Fields) == 0 {
+		return nil, nil
+	}
+	keys := map[string][]byte{}
+	for _, key := range cfg.Keys {
+		data, err := os.ReadFile(key.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys[key.ID], err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %s: %w", key.ID, err)
+		}
+	}
+	return fieldcrypt.New(cfg.ActiveKey, keys, cfg.Fields)
+}
+
+// SetEncryption enables encryption at rest of the fields keyring protects.
+func (s *DatabaseService) SetEncryption(keyring *fieldcrypt.Keyring) {
+	s.fields = keyring
+}
+
+// sealData encrypts the sensitive fields of a new version of record id.
+// Values that did not change since the current version keep their stored
+// ciphertext, so the version's patch only holds what changed. Values that
+// are already encrypted for this tenant, record and field, as a caller
+// without decrypt access reads them, are stored unchanged.
+func (s *DatabaseService) sealData(ctx context.Context, id int64, data map[string]interface{}) (map[string]interface{}, error) {
+	if s.fields == nil {
+		return data, nil
+	}
+	tenant := TenantFromContext(ctx)
+	var previous map[string]interface{}
+	latest, err := s.store(ctx).GetLastestRecordByID(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if latest != nil {
+		previous = latest.Data
+	}
+
+	sealed := map[string]interface{}{}
+	for key, value := range data {
+		sealed[key] = value
+		if !s.fields.Sensitive(key) {
+			continue
+		}
+		if stored, ok := previous[key]; ok {
+			if entity.EqualValues(stored, value) {
+				sealed[key] = stored
+				continue
+			}
+			if plaintext, err := s.fields.Open(tenant, id, key, stored); err == nil && entity.EqualValues(plaintext, value) {
+				sealed[key] = stored
+				continue
+			}
+		}
+		sealed[key], err = s.sealValue(tenant, id, key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// sealPatch encrypts the sensitive values a patch sets. A sensitive field is
+// encrypted whole, so a patch cannot set values inside it.
+func (s *DatabaseService) sealPatch(ctx context.Context, id int64, patch entity.Patch) (entity.Patch, error) {
+	if s.fields == nil {
+		return patch, nil
+	}
+	sealed := entity.Patch{}
+	for key, value := range patch {
+		sealed[key] = value
+		path, err := entity.ParsePath(key)
+		if err != nil {
+			return nil, err
+		}
+		if value == entity.Unset || !s.fields.Sensitive(path[0]) {
+			continue
+		}
+		if len(path) > 1 {
+			return nil, fmt.Errorf("%w: %s is encrypted and can only be set whole", entity.ErrInvalidPath, path[0])
+		}
+		sealed[key], err = s.sealValue(TenantFromContext(ctx), id, path[0], value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+// sealValue encrypts value unless it already is encrypted for the tenant,
+// record and field. Values in the legacy format are not bound to a tenant,
+// so they are encrypted again like any other value.
+func (s *DatabaseService) sealValue(tenant string, id int64, key string, value interface{}) (interface{}, error) {
+	if fieldcrypt.Bound(value) {
+		if _, err := s.fields.Open(tenant, id, key, value); err == nil {
+			return value, nil
+		}
+	}
+	return s.fields.Seal(tenant, id, key, value)
+}
+
+// reveal decrypts the encrypted values of record in place when the caller
+// may read them. Values that cannot be decrypted are left as stored.
+func (s *DatabaseService) reveal(ctx context.Context, record entity.Record) entity.Record {
+	if s.fields == nil || !canDecrypt(ctx) {
+		return record
+	}
+	tenant := TenantFromContext(ctx)
+	for key, value := range record.Data {
+		record.Data[key] = s.open(tenant, record.ID, key, value)
+	}
+	// patch keys are paths; only whole fields are encrypted
+	for key, value := range record.Patch {
+		if path, err := entity.ParsePath(key); err == nil && len(path) == 1 && value != entity.Unset {
+			record.Patch[key] = s.open(tenant, record.ID, path[0], value)
+		}
+	}
+	return record
+}
+
+// plaintext decrypts the encrypted values of record in place, whatever the
+// caller may read, for changes that copy values from one record to another.
+// Values that cannot be decrypted are left as stored.
+func (s *DatabaseService) plaintext(ctx context.Context, record entity.Record) entity.Record {
+	if s.fields == nil {
+		return record
+	}
+	tenant := TenantFromContext(ctx)
+	for key, value := range record.Data {
+		record.Data[key] = s.open(tenant, record.ID, key, value)
+	}
+	return record
+}
+
+func (s *DatabaseService) open(tenant string, id int64, key string, value interface{}) interface{} {
+	if _, ok := fieldcrypt.KeyID(value); !ok {
+		return value
+	}
+	plaintext, err := s.fields.Open(tenant, id, key, value)
+	if err != nil {
+		log.Printf("could not decrypt %s of record %d: %v", key, id, err)
+		return value
+	}
+	return plaintext
+}
+
+// ReencryptRecords rewrites every stored version, in every tenant, so its
+// sensitive fields are encrypted with the active key: values under older
+// keys or in the legacy format, which is not bound to the tenant, are
+// re-encrypted and plaintext left from before a field was configured is
+// encrypted. The hash chain of each changed record is sealed
+// again, with the old hashes kept in a re-seal checkpoint signed by the
+// receipt key when one is configured, and the rewrite audited in its tenant.
+// Records under legal hold are skipped; a record whose hash chain does not
+// verify stops the job. It returns how many versions changed.
+func (s *DatabaseService) ReencryptRecords(ctx context.Context) (int, error) {
+	if s.fields == nil {
+		return 0, nil
+	}
+	tenants, err := s.storage.GetTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, tenant := range tenants {
+		store := s.storage.WithTenant(tenant)
+		ids, err := store.GetRecordIDs()
+		if err != nil {
+			return total, err
+		}
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+			changed, err := store.RewriteRecordData(id, s.reencryptVersion(tenant, id), entity.AuditEntry{
+				Actor:  ActorFromContext(ctx),
+				Action: "record.reencrypt",
+				Detail: "key " + s.fields.ActiveKey(),
+			}, s.signPayload())
+			if errors.Is(err, storage.ErrLegalHold) {
+				log.Printf("skipping record %d of tenant %q: under legal hold", id, tenant)
+				continue
+			}
+			if err != nil {
+				return total, err
+			}
+			total += changed
+		}
+	}
+	return total, nil
+}
+
+// reencryptVersion returns the rewrite applied to each version of record id
+// of tenant, oldest first. A value unchanged from the previous version reuses
+// its new ciphertext, so stored patches stay as small as before.
+func (s *DatabaseService) reencryptVersion(tenant string, id int64) func(record *entity.Record) (map[string]interface{}, error) {
+	type sealedValue struct{ plaintext, ciphertext interface{} }
+	last := map[string]sealedValue{}
+	return func(record *entity.Record) (map[string]interface{}, error) {
+		data := map[string]interface{}{}
+		for key, value := range record.Data {
+			data[key] = value
+			keyID, encrypted := fieldcrypt.KeyID(value)
+			if !encrypted && !s.fields.Sensitive(key) {
+				continue
+			}
+			plaintext := value
+			if encrypted {
+				var err error
+				plaintext, err = s.fields.Open(tenant, id, key, value)
+				if err != nil && !s.fields.Sensitive(key) {
+					continue // plaintext that only looks encrypted
+				}
+				if err != nil {
+					return nil, fmt.Errorf("record %d version %d field %s: %w", id, record.Version, key, err)
+				}
+				if keyID == s.fields.ActiveKey() && fieldcrypt.Bound(value) {
+					last[key] = sealedValue{plaintext, value}
+					continue
+				}
+			}
+			if previous, ok := last[key]; ok && entity.EqualValues(previous.plaintext, plaintext) {
+				data[key] = previous.ciphertext
+				continue
+			}
+			ciphertext, err := s.fields.Seal(tenant, id, key, plaintext)
+			if err != nil {
+				return nil, err
+			}
+			data[key] = ciphertext
+			last[key] = sealedValue{plaintext, ciphertext}
+		}
+		return data, nil
+	}
+}
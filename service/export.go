@@ -0,0 +1,157 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/fieldcrypt"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// ErrLegacyCiphertext is returned when importing values encrypted in the
+// format that is not bound to a tenant. Run reencrypt on the exporting
+// database first.
+var ErrLegacyCiphertext = errors.New("value is encrypted in the legacy format, which is not bound to a tenant")
+
+// ErrUnknownExportFormat is returned when importing an export written in a
+// format this version does not know, such as by a newer one.
+var ErrUnknownExportFormat = errors.New("export is in an unknown format")
+
+// ErrImportConflict is returned when an imported version already exists with
+// different data. The error names the version.
+var ErrImportConflict = storage.ErrImportConflict
+
+// exportFormat is the version of the export format, written on its first
+// line. Exports without that line were written while null in a patch
+// deleted a key; since format 2 it is a value and Unset deletes.
+const exportFormat = 2
+
+// exportHeader is the first line of an export.
+type exportHeader struct {
+	Format int `json:"format"`
+}
+
+// ExportRecords writes every version matching filter to w as NDJSON, one
+// record per line in sequence order after a header line with the format,
+// and returns how many versions it wrote. Rows are
+// streamed from the storage cursor so memory use does not grow with history.
+func (s *DatabaseService) ExportRecords(ctx context.Context, filter storage.ExportFilter, w io.Writer) (int, error) {
+	cursor, err := s.store(ctx).ExportRecords(filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(exportHeader{Format: exportFormat}); err != nil {
+		return 0, err
+	}
+	count := 0
+	for cursor.Next() {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		if err := encoder.Encode(cursor.Record()); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, cursor.Err()
+}
+
+// ImportRecords reads NDJSON as produced by ExportRecords and stores each
+// version with its original version number and timestamps. Exports without
+// a header line are read as written before format 2, so a null in a patch
+// for a key the data does not have deletes it. Versions already present are
+// skipped, unless their data differs, which fails the import with
+// ErrImportConflict. Versions of records that have a type must match the schema that
+// applied when they were written.
+func (s *DatabaseService) ImportRecords(ctx context.Context, r io.Reader) (imported int, skipped int, err error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	types := map[int64]string{}
+	first, legacy := true, false
+	return s.store(ctx).ImportRecords(func() (*entity.Record, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var line json.RawMessage
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			first = false
+			var header struct {
+				Format *int `json:"format"`
+			}
+			if err := json.Unmarshal(line, &header); err != nil {
+				return nil, err
+			}
+			if header.Format == nil {
+				legacy = true
+			} else if *header.Format != exportFormat {
+				return nil, fmt.Errorf("%w: %d", ErrUnknownExportFormat, *header.Format)
+			} else {
+				line = nil
+				err := decoder.Decode(&line)
+				if err == io.EOF {
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		record := &entity.Record{}
+		lineDecoder := json.NewDecoder(bytes.NewReader(line))
+		lineDecoder.UseNumber() // values keep their types
+		if err := lineDecoder.Decode(record); err != nil {
+			return nil, err
+		}
+		if legacy {
+			unsetLegacyNulls(record)
+		}
+		if record.ID <= 0 {
+			return nil, ErrRecordIDInvalid
+		}
+		if record.ID > entity.MaxRecordID {
+			return nil, fmt.Errorf("%w: %d", ErrRecordIDTooLarge, record.ID)
+		}
+		for key, value := range record.Data {
+			if _, encrypted := fieldcrypt.KeyID(value); encrypted && !fieldcrypt.Bound(value) {
+				return nil, fmt.Errorf("%w: record %d field %s", ErrLegacyCiphertext, record.ID, key)
+			}
+		}
+		if err := s.checkHistoricalType(ctx, record, types); err != nil {
+			return nil, fmt.Errorf("record %d version %d: %w", record.ID, record.Version, err)
+		}
+		return record, nil
+	})
+}
+
+// unsetLegacyNulls converts the nulls in the patch of a version exported
+// before format 2 that deleted a key into Unset. A null for a key the data
+// still holds was set as a value.
+func unsetLegacyNulls(record *entity.Record) {
+	for key, value := range record.Patch {
+		if value != nil {
+			continue
+		}
+		exists := false
+		if segments, err := entity.ParsePath(key); err == nil {
+			_, exists = entity.Lookup(record.Data, segments)
+		}
+		if !exists {
+			record.Patch[key] = entity.Unset
+		}
+	}
+}
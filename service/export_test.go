@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+func TestExportRoundTripKeepsNullValues(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	for i, data := range []map[string]interface{}{{"a": "x", "b": "y"}, {"a": nil}} {
+		operation := entity.OperationUpdate
+		if i == 0 {
+			operation = entity.OperationCreate
+		}
+		if _, err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: data, Operation: operation}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var export bytes.Buffer
+	if _, err := s.ExportRecords(ctx, storage.ExportFilter{}, &export); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(export.String(), `{"format":2}`+"\n") {
+		t.Fatalf("export starts with %q", strings.SplitN(export.String(), "\n", 2)[0])
+	}
+
+	target := newTestService(t)
+	if imported, _, err := target.ImportRecords(ctx, &export); err != nil || imported != 2 {
+		t.Fatalf("imported %d versions: %v", imported, err)
+	}
+	record, err := target.GetRecordByVersion(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := record.Patch["a"]; !ok || value != nil {
+		t.Fatalf("null value imported as %v", record.Patch["a"])
+	}
+	if record.Patch["b"] != entity.Unset {
+		t.Fatalf("deleted key imported as %v", record.Patch["b"])
+	}
+}
+
+func TestImportReadsHeaderlessPatchNullsAsDeletes(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	legacy := `{"id":1,"version":1,"data":{"a":"x","b":"y"},"created_at":"2023-05-20T06:23:51Z","patch":{"a":"x","b":"y"}}
+{"id":1,"version":2,"data":{"a":null},"created_at":"2023-05-21T06:23:51Z","patch":{"a":null,"b":null}}
+`
+	if _, _, err := s.ImportRecords(ctx, strings.NewReader(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	record, err := s.GetRecordByVersion(ctx, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := record.Patch["a"]; !ok || value != nil {
+		t.Fatalf("null value of a key the data holds imported as %v", record.Patch["a"])
+	}
+	if record.Patch["b"] != entity.Unset {
+		t.Fatalf("null of a key the data lacks imported as %v", record.Patch["b"])
+	}
+}
+
+func TestImportRefusesUnknownFormats(t *testing.T) {
+	s := newTestService(t)
+	_, _, err := s.ImportRecords(tenantContext(""), strings.NewReader(`{"format":3}`+"\n"))
+	if !errors.Is(err, ErrUnknownExportFormat) {
+		t.Fatalf("importing format 3: %v", err)
+	}
+}
+
+func TestImportReportsVersionsWithDifferentData(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	version := `{"id":1,"version":1,"data":{"a":"x"},"created_at":"2023-05-20T06:23:51Z"}` + "\n"
+	if _, _, err := s.ImportRecords(ctx, strings.NewReader(version)); err != nil {
+		t.Fatal(err)
+	}
+	if imported, skipped, err := s.ImportRecords(ctx, strings.NewReader(version)); err != nil || imported != 0 || skipped != 1 {
+		t.Fatalf("importing the same version again imported %d, skipped %d: %v", imported, skipped, err)
+	}
+	changed := `{"id":2,"version":1,"data":{},"created_at":"2023-05-20T06:23:51Z"}` + "\n" + strings.Replace(version, `"x"`, `"y"`, 1)
+	if _, _, err := s.ImportRecords(ctx, strings.NewReader(changed)); !errors.Is(err, ErrImportConflict) {
+		t.Fatalf("importing a version with different data: %v", err)
+	}
+	if _, err := s.GetLastestRecordByID(ctx, 2); !errors.Is(err, ErrRecordDoesNotExist) {
+		t.Fatalf("a conflicting import stored other versions: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/temelpa/timetravel/storage"
+)
+
+// newTestService returns a service over a new database in a temporary
+// directory, storing every version but the first as a patch.
+func newTestService(t *testing.T) *DatabaseService {
+	t.Helper()
+	return newTestServiceAt(t, filepath.Join(t.TempDir(), "test.db"))
+}
+
+// newTestServiceAt is newTestService over the database at path, for tests
+// that change stored rows behind the service's back.
+func newTestServiceAt(t *testing.T, path string) *DatabaseService {
+	t.Helper()
+	store, err := storage.NewStorageAt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	store.SetSnapshotInterval(100)
+	s := NewDatabaseService(store)
+	return &s
+}
+
+// tenantContext returns the context of an admin of tenant.
+func tenantContext(tenant string) context.Context {
+	return WithActor(WithTenant(context.Background(), tenant), "test:"+tenant)
+}
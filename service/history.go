@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// GetFieldHistory returns the versions of a record, in the order they were
+// stored, in which the value at path was set, changed or removed. If the
+// record was split off another and path is under a key split off, the
+// changes the source made before the split come first, marked with the id
+// of the source, if the caller may read the source. The other history reads
+// only cover the record itself.
+func (s *DatabaseService) GetFieldHistory(ctx context.Context, id int64, path string) ([]entity.FieldChange, error) {
+	segments, err := entity.ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	records, err := s.GetAllRecordsByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []entity.FieldChange{}
+	var previous interface{}
+	existed := false
+	origin, err := s.splitOrigin(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	inherits := origin != nil && len(segments) > 0 && origin.HasKey(segments[0])
+	if inherits {
+		// the source is left out for callers that may not read it
+		err := s.Authorize(ctx, AccessRead, origin.SourceID)
+		if errors.Is(err, ErrForbidden) {
+			inherits = false
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if inherits {
+		inherited, err := s.GetFieldHistory(ctx, origin.SourceID, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range inherited {
+			// changes the source inherited itself are all older than the split
+			if change.RecordID == 0 {
+				if change.Version > origin.SourceVersion {
+					continue
+				}
+				change.RecordID = origin.SourceID
+			}
+			changes = append(changes, change)
+			previous, existed = change.Value, !change.Removed
+		}
+	}
+	for _, record := range records {
+		value, exists := entity.Lookup(record.Data, segments)
+		if exists == existed && entity.EqualValues(value, previous) {
+			continue
+		}
+		changes = append(changes, entity.FieldChange{
+			Version:     record.Version,
+			Operation:   record.Operation,
+			Author:      record.Author,
+			CreatedAt:   record.CreatedAt,
+			EffectiveAt: record.EffectiveAt,
+			Value:       value,
+			Removed:     !exists,
+		})
+		previous, existed = value, exists
+	}
+	return changes, nil
+}
+
+// DiffVersions returns the change from version from of a record to version
+// to, where version 0 is the empty record before it was created. With a
+// path, only the changes at or below it are returned.
+func (s *DatabaseService) DiffVersions(ctx context.Context, id int64, from, to int, path string) (entity.VersionDiff, error) {
+	var prefix []string
+	if path != "" {
+		var err error
+		prefix, err = entity.ParsePath(path)
+		if err != nil {
+			return entity.VersionDiff{}, err
+		}
+	}
+
+	data := map[int]map[string]interface{}{}
+	for _, version := range []int{from, to} {
+		if version == 0 {
+			continue
+		}
+		record, err := s.GetRecordByVersion(ctx, id, version)
+		if err != nil {
+			return entity.VersionDiff{}, err
+		}
+		data[version] = record.Data
+	}
+
+	changes := entity.Patch{}
+	for key, value := range entity.Diff(data[from], data[to]) {
+		if segments, err := entity.ParsePath(key); err == nil && hasPrefix(segments, prefix) {
+			changes[key] = value
+		}
+	}
+	return entity.VersionDiff{ID: id, From: from, To: to, Changes: changes}, nil
+}
+
+// hasPrefix reports whether path starts with prefix, segment by segment. A
+// change to a parent of prefix counts too, as it changes what prefix holds.
+func hasPrefix(path, prefix []string) bool {
+	for i := 0; i < len(path) && i < len(prefix); i++ {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
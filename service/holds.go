@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrRecordOnHold = errors.New("record is under legal hold")
+var ErrHoldNotActive = errors.New("legal hold does not exist or was already lifted")
+var ErrHoldIncomplete = errors.New("a legal hold needs a case reference")
+
+// holdError translates storage hold errors into service errors.
+func holdError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrLegalHold):
+		return ErrRecordOnHold
+	case errors.Is(err, storage.ErrHoldNotActive):
+		return ErrHoldNotActive
+	}
+	return err
+}
+
+// PlaceLegalHold freezes a record's history on behalf of the caller.
+// Destructive operations on the record are refused until the hold is lifted.
+func (s *DatabaseService) PlaceLegalHold(ctx context.Context, recordID int64, caseRef string) (entity.LegalHold, error) {
+	if recordID <= 0 {
+		return entity.LegalHold{}, ErrRecordIDInvalid
+	}
+	if caseRef == "" {
+		return entity.LegalHold{}, ErrHoldIncomplete
+	}
+	hold, err := s.store(ctx).PlaceLegalHold(recordID, caseRef, ActorFromContext(ctx))
+	if err != nil {
+		return entity.LegalHold{}, err
+	}
+	return *hold, nil
+}
+
+// LiftLegalHold ends an active hold on behalf of the caller.
+func (s *DatabaseService) LiftLegalHold(ctx context.Context, holdID int) (entity.LegalHold, error) {
+	hold, err := s.store(ctx).LiftLegalHold(holdID, ActorFromContext(ctx))
+	if err != nil {
+		return entity.LegalHold{}, holdError(err)
+	}
+	return *hold, nil
+}
+
+// GetLegalHolds lists every hold placed on a record, lifted ones included.
+func (s *DatabaseService) GetLegalHolds(ctx context.Context, recordID int64) ([]entity.LegalHold, error) {
+	return s.store(ctx).GetLegalHolds(recordID)
+}
+
+// GetAuditEntries returns the audit trail of a record.
+func (s *DatabaseService) GetAuditEntries(ctx context.Context, recordID int64) ([]entity.AuditEntry, error) {
+	return s.store(ctx).GetAuditEntries(recordID)
+}
+
+// DeleteRecord marks a record deleted. It fails with ErrRecordOnHold while
+// the record is under legal hold.
+func (s *DatabaseService) DeleteRecord(ctx context.Context, id int64) error {
+	if _, err := s.GetLastestRecordByID(ctx, id); err != nil {
+		return err
+	}
+	return holdError(s.store(ctx).DeleteRecord(id, ActorFromContext(ctx)))
+}
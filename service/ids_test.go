@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+func TestCreateRecordOnlyCreatesOnce(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	record := entity.Record{ID: 1, Data: map[string]interface{}{"n": "x"}, Operation: entity.OperationCreate}
+	if _, err := s.CreateRecord(ctx, record, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRecord(ctx, record, ""); !errors.Is(err, ErrRecordAlreadyExists) {
+		t.Fatalf("creating an existing record: %v", err)
+	}
+	versions, err := s.GetAllRecordsByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("record has %d versions after a refused create", len(versions))
+	}
+}
+
+func TestCreateRecordBoundsIDs(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	data := map[string]interface{}{"n": "x"}
+	if _, err := s.CreateRecord(ctx, entity.Record{ID: entity.MaxRecordID, Data: data, Operation: entity.OperationCreate}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRecord(ctx, entity.Record{ID: entity.MaxRecordID + 1, Data: data, Operation: entity.OperationCreate}, ""); !errors.Is(err, ErrRecordIDTooLarge) {
+		t.Fatalf("creating a record above the largest id: %v", err)
+	}
+	if _, err := s.CreateNewRecord(ctx, data, ""); !errors.Is(err, storage.ErrRecordIDsExhausted) {
+		t.Fatalf("assigning an id after the largest one: %v", err)
+	}
+}
+
+func TestNextRecordIDSkipsLegacyLargeIDs(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	// stored before ids were bounded
+	if _, err := s.store(ctx).InsertRecord(math.MaxInt64, map[string]interface{}{"n": "x"}, storage.VersionMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		record, err := s.CreateNewRecord(ctx, map[string]interface{}{"n": "y"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.ID != want {
+			t.Fatalf("assigned id %d, want %d", record.ID, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/jwtauth"
+)
+
+// defaultTokenLeeway is the clock skew tolerated when the config sets none.
+const defaultTokenLeeway = time.Minute
+
+// errNoTenant is returned for tokens without exactly one tenant when a
+// tenant claim is configured.
+var errNoTenant = errors.New("token does not name exactly one tenant")
+
+// tokenAuth validates bearer tokens and maps their roles to scopes.
+type tokenAuth struct {
+	verifier    *jwtauth.Verifier
+	rolesClaim  string
+	tenantClaim string
+	roles       map[string][]string
+}
+
+// SetTokenAuth enables bearer tokens as configured by cfg, loading its JWKS
+// file. It does nothing if cfg names no JWKS file.
+func (s *DatabaseService) SetTokenAuth(cfg config.JWTConfig) error {
+	if cfg.JWKSFile == "" {
+		return nil
+	}
+	keys, err := jwtauth.LoadJWKS(cfg.JWKSFile)
+	if err != nil {
+		return err
+	}
+	leeway := time.Duration(cfg.Leeway)
+	if leeway == 0 {
+		leeway = defaultTokenLeeway
+	}
+	rolesClaim := cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	s.tokens = &tokenAuth{
+		verifier:    jwtauth.NewVerifier(keys, cfg.Issuer, cfg.Audience, leeway),
+		rolesClaim:  rolesClaim,
+		tenantClaim: cfg.TenantClaim,
+		roles:       cfg.Roles,
+	}
+	return nil
+}
+
+// authenticateToken validates a bearer token. The principal's subject is the
+// token's sub claim, its tenant that named by the tenant claim, if one is
+// configured, and its scopes are those granted to its roles.
+func (t *tokenAuth) authenticateToken(token string) (entity.Principal, error) {
+	claims, err := t.verifier.Verify(token, time.Now())
+	if err != nil {
+		return entity.Principal{}, err
+	}
+	principal := entity.Principal{Subject: claims.Subject(), Roles: claims.Strings(t.rolesClaim)}
+	if t.tenantClaim != "" {
+		tenants := claims.Strings(t.tenantClaim)
+		if len(tenants) != 1 {
+			return entity.Principal{}, errNoTenant
+		}
+		principal.Tenant = tenants[0]
+	}
+	granted := map[string]bool{}
+	for _, role := range principal.Roles {
+		for _, scope := range t.roles[role] {
+			if !granted[scope] {
+				granted[scope] = true
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrInvalidLinkType = errors.New("link types may only contain letters, digits, '-' and '_'")
+var ErrInvalidLink = errors.New("a link needs another record to point to and must end after it starts")
+var ErrLinkOverlaps = errors.New("a link of that type between those records is already in effect during that time")
+var ErrLinkNotActive = errors.New("link does not exist or is not in effect at that time")
+
+// linkError translates storage link errors into service errors.
+func linkError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrLinkOverlaps):
+		return ErrLinkOverlaps
+	case errors.Is(err, storage.ErrLinkNotActive):
+		return ErrLinkNotActive
+	}
+	return err
+}
+
+// AddLink links one record to another with a type such as employs or
+// located_at, in effect from link.ValidFrom, or now if it is zero, until
+// link.ValidTo, or indefinitely if it is nil. Both records must exist and
+// the caller must be allowed to read the record linked to.
+func (s *DatabaseService) AddLink(ctx context.Context, link entity.Link) (entity.Link, error) {
+	if !typeName.MatchString(link.Type) {
+		return entity.Link{}, ErrInvalidLinkType
+	}
+	if link.ValidFrom.IsZero() {
+		link.ValidFrom = time.Now()
+	}
+	if link.ToID <= 0 || link.ToID == link.FromID || (link.ValidTo != nil && !link.ValidTo.After(link.ValidFrom)) {
+		return entity.Link{}, ErrInvalidLink
+	}
+	if _, err := s.GetLastestRecordByID(ctx, link.FromID); err != nil {
+		return entity.Link{}, err
+	}
+	if err := s.Authorize(ctx, AccessRead, link.ToID); err != nil {
+		return entity.Link{}, err
+	}
+	if _, err := s.GetLastestRecordByID(ctx, link.ToID); err != nil {
+		return entity.Link{}, err
+	}
+
+	link.CreatedBy = ActorFromContext(ctx)
+	added, err := s.store(ctx).AddLink(link)
+	if err != nil {
+		return entity.Link{}, linkError(err)
+	}
+	return *added, nil
+}
+
+// EndLink ends a link of the record, from either side, at the given time, or
+// now if it is zero. As with AddLink, the time may be in the past, so an end
+// reported late is recorded when it happened; the audit trail keeps when it
+// was recorded. The link stays on record with its interval.
+func (s *DatabaseService) EndLink(ctx context.Context, recordID int64, linkID int, at time.Time) (entity.Link, error) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	link, err := s.store(ctx).EndLink(recordID, linkID, at, ActorFromContext(ctx))
+	if err != nil {
+		return entity.Link{}, linkError(err)
+	}
+	return *link, nil
+}
+
+// GetLinks lists the links from and to a record that match filter.
+func (s *DatabaseService) GetLinks(ctx context.Context, recordID int64, filter storage.LinkFilter) ([]entity.Link, error) {
+	return s.store(ctx).GetLinks(recordID, filter)
+}
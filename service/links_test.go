@@ -0,0 +1,64 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+func TestEndLinkRecordsLateEnds(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	for _, id := range []int64{1, 2} {
+		if _, err := s.CreateRecord(ctx, entity.Record{ID: id, Data: map[string]interface{}{"n": "x"}, Operation: entity.OperationCreate}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-30 * 24 * time.Hour)
+	link, err := s.AddLink(ctx, entity.Link{FromID: 1, ToID: 2, Type: "employs", ValidFrom: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a termination ten days ago, reported today
+	end := time.Now().Add(-10 * 24 * time.Hour)
+	ended, err := s.EndLink(ctx, 2, link.ID, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ended.ValidTo == nil || !ended.ValidTo.Equal(end.UTC()) {
+		t.Fatalf("link ended at %v has valid_to %v", end, ended.ValidTo)
+	}
+
+	for _, check := range []struct {
+		asOf    time.Time
+		covered bool
+	}{
+		{end.Add(-time.Hour), true},
+		{end.Add(time.Hour), false},
+		{time.Now(), false},
+	} {
+		links, err := s.GetLinks(ctx, 1, storage.LinkFilter{Type: "employs", AsOf: check.asOf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if covered := len(links) == 1; covered != check.covered {
+			t.Fatalf("as of %v the link is in effect: %v", check.asOf, covered)
+		}
+	}
+
+	audit, err := s.GetAuditEntries(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endedAt := end.UTC().Format(time.RFC3339Nano)
+	for _, entry := range audit {
+		if entry.Action == "link.end" && strings.Contains(entry.Detail, endedAt) {
+			return
+		}
+	}
+	t.Fatalf("no link.end audit entry names the end time %s: %+v", endedAt, audit)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrInvalidMerge = errors.New("a record can only be merged into another record, with strategy keep_into, keep_from or reject")
+var ErrRecordMerged = errors.New("record was already merged into another")
+var ErrMergeTooEarly = errors.New("a merge cannot take effect before the latest version of either record")
+
+// MergeConflictError is returned when a merge with the reject strategy finds
+// keys the two records have with different values.
+type MergeConflictError struct {
+	Keys []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("records disagree on %s", strings.Join(e.Keys, ", "))
+}
+
+// MergeRecord merges the duplicate fromID into intoID from mergedAt on, or
+// from now if it is zero. The current data of both is combined according to
+// strategy and stored as a new version of intoID in effect from mergedAt,
+// which must still match its type. mergedAt may not be before the latest
+// version of either record takes effect, so the combined version is not
+// hidden by a later one. Reads of fromID at mergedAt or later, as known once
+// the merge is recorded, are redirected to intoID; the history of fromID is
+// kept as it was. The caller must be allowed to write both records. If
+// either record changes while the merge is made, ErrRecordChanged is
+// returned.
+func (s *DatabaseService) MergeRecord(ctx context.Context, fromID, intoID int64, strategy string, mergedAt time.Time) (entity.Merge, entity.Record, error) {
+	if intoID <= 0 || intoID == fromID {
+		return entity.Merge{}, entity.Record{}, ErrInvalidMerge
+	}
+	switch strategy {
+	case entity.MergeKeepInto, entity.MergeKeepFrom, entity.MergeReject:
+	default:
+		return entity.Merge{}, entity.Record{}, ErrInvalidMerge
+	}
+	if mergedAt.IsZero() {
+		mergedAt = time.Now()
+	}
+	if mergedAt.After(time.Now()) {
+		return entity.Merge{}, entity.Record{}, ErrEffectiveTimeInFuture
+	}
+	if err := s.Authorize(ctx, AccessWrite, intoID); err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+
+	// values are copied between records, so encrypted ones are combined in
+	// plaintext whatever the caller may read
+	from, err := getRecord(s.store(ctx).GetLastestRecordByID(fromID))
+	if err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+	into, err := getRecord(s.store(ctx).GetLastestRecordByID(intoID))
+	if err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+	if mergedAt.Before(from.EffectiveAt) || mergedAt.Before(into.EffectiveAt) {
+		return entity.Merge{}, entity.Record{}, ErrMergeTooEarly
+	}
+	combined, conflicts := entity.MergeData(s.plaintext(ctx, into).Data, s.plaintext(ctx, from).Data, strategy)
+	if combined == nil {
+		return entity.Merge{}, entity.Record{}, &MergeConflictError{Keys: conflicts}
+	}
+
+	if _, err := s.checkType(ctx, intoID, "", combined); err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+	data, err := s.sealData(ctx, intoID, combined)
+	if err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+	merge, stored, err := s.store(ctx).MergeRecord(entity.Merge{
+		FromID:   fromID,
+		IntoID:   intoID,
+		MergedAt: mergedAt,
+		Strategy: strategy,
+		MergedBy: ActorFromContext(ctx),
+	}, data, from.Seq, into.Seq, storage.VersionMeta{
+		Operation: entity.OperationMerge,
+		Author:    authorFromContext(ctx),
+	})
+	if errors.Is(err, storage.ErrRecordMerged) {
+		return entity.Merge{}, entity.Record{}, ErrRecordMerged
+	}
+	if errors.Is(err, storage.ErrRecordChanged) {
+		return entity.Merge{}, entity.Record{}, ErrRecordChanged
+	}
+	if err != nil {
+		return entity.Merge{}, entity.Record{}, err
+	}
+	return *merge, s.reveal(ctx, stored.Copy()), nil
+}
+
+// MergedInto returns the id a record was merged into, or 0 if it was not
+// merged.
+func (s *DatabaseService) MergedInto(ctx context.Context, id int64) (int64, error) {
+	merge, err := s.store(ctx).GetMerge(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return merge.IntoID, nil
+}
+
+// GetMerges lists the merges of a record into another and of other records
+// into it.
+func (s *DatabaseService) GetMerges(ctx context.Context, id int64) ([]entity.Merge, error) {
+	return s.store(ctx).GetMerges(id)
+}
+
+// ResolveMerges returns the id of the record that holds the data of id at
+// asOf, following merges in effect then as recorded by knownAt. Ids that were
+// not merged resolve to themselves.
+func (s *DatabaseService) ResolveMerges(ctx context.Context, id int64, asOf, knownAt time.Time) (int64, error) {
+	return s.store(ctx).ResolveMerges(id, asOf, knownAt)
+}
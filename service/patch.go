@@ -0,0 +1,292 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch document")
+var ErrPatchTestFailed = errors.New("patch test operation failed")
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to a copy of data.
+// Objects in the patch are merged into the objects they address, and, as
+// the RFC defines, a null member deletes the key, so merge patches cannot set
+// a value to null.
+func ApplyMergePatch(data map[string]interface{}, patch []byte) (map[string]interface{}, error) {
+	members, err := entity.DecodeValue(patch)
+	if _, ok := members.(map[string]interface{}); err != nil || !ok {
+		return nil, fmt.Errorf("%w: merge patch must be a JSON object", ErrInvalidPatch)
+	}
+	return mergeValue(entity.CopyData(data), members).(map[string]interface{}), nil
+}
+
+// mergeValue merges patch into target, which it may change in place.
+func mergeValue(target, patch interface{}) interface{} {
+	members, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+	}
+	for key, value := range members {
+		if value == nil {
+			delete(object, key)
+		} else {
+			object[key] = mergeValue(object[key], value)
+		}
+	}
+	return object
+}
+
+// jsonPatchOperation is a single RFC 6902 operation. Value is kept raw so a
+// null value can be told apart from a missing one.
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// value decodes the operation's value, which the op requires.
+func (o jsonPatchOperation) value() (interface{}, error) {
+	if o.Value == nil {
+		return nil, fmt.Errorf("%w: %s requires a value", ErrInvalidPatch, o.Op)
+	}
+	value, err := entity.DecodeValue(o.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return value, nil
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to a copy of data. Paths
+// are RFC 6901 pointers such as `/contacts/primary/phone`; `-` appends to
+// an array. The patch is atomic: if any operation fails, including a `test`,
+// data is left untouched and an error is returned. A failed `test` returns
+// ErrPatchTestFailed.
+func ApplyJSONPatch(data map[string]interface{}, patch []byte) (map[string]interface{}, error) {
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("%w: json patch must be an array of operations", ErrInvalidPatch)
+	}
+
+	result := entity.CopyData(data)
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	for i, operation := range operations {
+		if err := applyOperation(result, operation); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return result, nil
+}
+
+// applyOperation applies one operation to document in place.
+func applyOperation(document map[string]interface{}, operation jsonPatchOperation) error {
+	path, err := pointerPath(operation.Path)
+	if err != nil {
+		return err
+	}
+
+	switch operation.Op {
+	case "add", "replace":
+		value, err := operation.value()
+		if err != nil {
+			return err
+		}
+		if operation.Op == "replace" {
+			if err := updateAt(document, path, removeMember); err != nil {
+				return err
+			}
+		}
+		return updateAt(document, path, addMember(value))
+	case "remove":
+		return updateAt(document, path, removeMember)
+	case "move", "copy":
+		from, err := pointerPath(operation.From)
+		if err != nil {
+			return err
+		}
+		value, exists := entity.Lookup(document, from)
+		if !exists {
+			return fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, operation.From)
+		}
+		if operation.Op == "move" {
+			if strings.HasPrefix(operation.Path, operation.From+"/") {
+				return fmt.Errorf("%w: cannot move %q into itself", ErrInvalidPatch, operation.From)
+			}
+			if err := updateAt(document, from, removeMember); err != nil {
+				return err
+			}
+		}
+		return updateAt(document, path, addMember(entity.CopyValue(value)))
+	case "test":
+		value, err := operation.value()
+		if err != nil {
+			return err
+		}
+		if current, exists := entity.Lookup(document, path); !exists || !testEqual(current, value) {
+			return fmt.Errorf("%w: %q", ErrPatchTestFailed, operation.Path)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, operation.Op)
+}
+
+// memberUpdate changes the member key of container, an object or array, and
+// returns the container, which is new if an array changed length.
+type memberUpdate func(container interface{}, key string) (interface{}, error)
+
+// updateAt applies update to the parent of the value at path in document.
+func updateAt(document map[string]interface{}, path []string, update memberUpdate) error {
+	_, err := updateIn(document, path, update)
+	return err
+}
+
+func updateIn(container interface{}, path []string, update memberUpdate) (interface{}, error) {
+	if len(path) == 1 {
+		return update(container, path[0])
+	}
+	switch container := container.(type) {
+	case map[string]interface{}:
+		child, ok := container[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, path[0])
+		}
+		child, err := updateIn(child, path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[path[0]] = child
+		return container, nil
+	case []interface{}:
+		index, err := pointerIndex(path[0], len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := updateIn(container[index], path[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		container[index] = child
+		return container, nil
+	}
+	return nil, fmt.Errorf("%w: %q is not an object or array", ErrInvalidPatch, path[0])
+}
+
+// addMember sets an object member or inserts an array element.
+func addMember(value interface{}) memberUpdate {
+	return func(container interface{}, key string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			container[key] = value
+			return container, nil
+		case []interface{}:
+			index, err := pointerIndex(key, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		return nil, fmt.Errorf("%w: cannot add %q to a value that is not an object or array", ErrInvalidPatch, key)
+	}
+}
+
+// removeMember deletes an object member or array element, which must exist.
+func removeMember(container interface{}, key string) (interface{}, error) {
+	switch container := container.(type) {
+	case map[string]interface{}:
+		if _, ok := container[key]; !ok {
+			return nil, fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, key)
+		}
+		delete(container, key)
+		return container, nil
+	case []interface{}:
+		index, err := pointerIndex(key, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		return append(container[:index:index], container[index+1:]...), nil
+	}
+	return nil, fmt.Errorf("%w: %q does not exist", ErrInvalidPatch, key)
+}
+
+// pointerIndex parses an array index from a pointer segment. With end, "-"
+// and the array length address the position after the last element.
+func pointerIndex(segment string, length int, end bool) (int, error) {
+	if end && segment == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(segment)
+	limit := length
+	if end {
+		limit++
+	}
+	if err != nil || index < 0 || index >= limit || strconv.Itoa(index) != segment {
+		return 0, fmt.Errorf("%w: no array element %q", ErrInvalidPatch, segment)
+	}
+	return index, nil
+}
+
+// pointerPath decodes an RFC 6901 pointer into its segments. The whole
+// document cannot be addressed.
+func pointerPath(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with /", ErrInvalidPatch, pointer)
+	}
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segment = strings.ReplaceAll(segment, "~1", "/")
+		segments[i] = strings.ReplaceAll(segment, "~0", "~")
+	}
+	return segments, nil
+}
+
+// testEqual compares values as RFC 6902 tests do: numbers are equal when
+// their values are, however they are written.
+func testEqual(a, b interface{}) bool {
+	x, xIsNumber := a.(json.Number)
+	y, yIsNumber := b.(json.Number)
+	if xIsNumber && yIsNumber {
+		xValue, xOK := new(big.Rat).SetString(string(x))
+		yValue, yOK := new(big.Rat).SetString(string(y))
+		return xOK && yOK && xValue.Cmp(yValue) == 0
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, ok := b[key]; !ok || !testEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !testEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return entity.EqualValues(a, b)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+func TestApplyMergePatchEmptyKeys(t *testing.T) {
+	data := map[string]interface{}{"a": map[string]interface{}{"b": "c"}}
+	got, err := ApplyMergePatch(data, []byte(`{"":"x","a":{"":"y"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"": "x", "a": map[string]interface{}{"": "y", "b": "c"}}
+	if !entity.EqualValues(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestApplyJSONPatchEmptyKeys(t *testing.T) {
+	data := map[string]interface{}{"a": map[string]interface{}{}}
+	got, err := ApplyJSONPatch(data, []byte(`[{"op":"add","path":"/","value":"x"},{"op":"add","path":"/a/","value":"y"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"": "x", "a": map[string]interface{}{"": "y"}}
+	if !entity.EqualValues(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// Versions written with empty keys are stored as patches and must still be
+// read back through the delta chain.
+func TestEmptyKeysRoundTripThroughStorage(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+
+	steps := []map[string]interface{}{
+		{"a": "b"},
+		{"": "x", "a": "b"},
+		{"": "x", "a": map[string]interface{}{"": "y"}},
+		{"": map[string]interface{}{"": "z"}, "a": map[string]interface{}{"": "y", "c": "d"}},
+		{"a": map[string]interface{}{"c": "d"}},
+	}
+	for i, data := range steps {
+		operation := entity.OperationUpdate
+		if i == 0 {
+			operation = entity.OperationCreate
+		}
+		if _, err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: data, Operation: operation}, ""); err != nil {
+			t.Fatalf("version %d: %v", i+1, err)
+		}
+	}
+	for i, want := range steps {
+		record, err := s.GetRecordByVersion(ctx, 1, i+1)
+		if err != nil {
+			t.Fatalf("version %d: %v", i+1, err)
+		}
+		if !entity.EqualValues(record.Data, want) {
+			t.Errorf("version %d = %v, want %v", i+1, record.Data, want)
+		}
+	}
+	changes, err := s.GetFieldHistory(ctx, 1, `a.\e`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Errorf("history of a.\\e has %d changes, want 2: %+v", len(changes), changes)
+	}
+}
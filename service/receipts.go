@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/receipt"
+)
+
+var ErrReceiptsDisabled = errors.New("signed receipts are not configured")
+
+// LoadReceiptKeys reads the signing keys named in cfg. The signer is nil when
+// no active key is configured.
+func LoadReceiptKeys(cfg config.SigningConfig) (*receipt.Signer, receipt.KeySet, error) {
+	var signer *receipt.Signer
+	keys := receipt.KeySet{}
+	for _, key := range cfg.Keys {
+		if key.PrivateKeyFile != "" {
+			data, err := os.ReadFile(key.PrivateKeyFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			private, err := receipt.ParsePrivateKey(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			keySigner := receipt.NewSigner(key.ID, private)
+			keys[key.ID] = keySigner.PublicKey()
+			if key.ID == cfg.ActiveKey {
+				signer = keySigner
+			}
+			continue
+		}
+		data, err := os.ReadFile(key.PublicKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[key.ID], err = receipt.ParsePublicKey(data)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return signer, keys, nil
+}
+
+// SetReceiptKeys enables signed receipts. Receipts are signed by signer and
+// keys are published for verifying them.
+func (s *DatabaseService) SetReceiptKeys(signer *receipt.Signer, keys receipt.KeySet) {
+	s.signer = signer
+	s.receiptKeys = keys
+}
+
+// signPayload returns a function that signs payloads with the receipt key,
+// or nil when receipts are disabled.
+func (s *DatabaseService) signPayload() func(payload []byte) (string, string) {
+	if s.signer == nil {
+		return nil
+	}
+	return func(payload []byte) (string, string) {
+		return s.signer.KeyID(), s.signer.SignPayload(payload)
+	}
+}
+
+// ReceiptKeys returns the public keys receipts can be verified with.
+func (s *DatabaseService) ReceiptKeys(ctx context.Context) receipt.KeySet {
+	return s.receiptKeys
+}
+
+// IssueReceipt signs a statement of a record's state: the given version if
+// version is set, else the version in effect at asOf as known at knownAt if
+// asOf is set, else the latest version.
+func (s *DatabaseService) IssueReceipt(ctx context.Context, id int64, version int, asOf, knownAt time.Time) (*receipt.Receipt, error) {
+	if s.signer == nil {
+		return nil, ErrReceiptsDisabled
+	}
+
+	statement := receipt.Statement{Tenant: TenantFromContext(ctx), IssuedAt: time.Now().UTC()}
+	var record entity.Record
+	var err error
+	switch {
+	case version > 0:
+		record, err = s.GetRecordByVersion(ctx, id, version)
+	case !asOf.IsZero():
+		record, err = s.GetRecordAsKnownAt(ctx, id, asOf, knownAt)
+		asOf, knownAt = asOf.UTC(), knownAt.UTC()
+		statement.AsOf, statement.KnownAt = &asOf, &knownAt
+	default:
+		record, err = s.GetLastestRecordByID(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	statement.Record = record
+	return s.signer.Sign(statement)
+}
@@ -3,22 +3,26 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/temelpa/timetravel/entity"
 )
 
 var ErrRecordDoesNotExist = errors.New("record with that id does not exist")
 var ErrRecordIDInvalid = errors.New("record id must >= 0")
+var ErrRecordIDTooLarge = fmt.Errorf("record id must be at most %d", entity.MaxRecordID)
 var ErrRecordAlreadyExists = errors.New("record already exists")
+var ErrEffectiveTimeInFuture = errors.New("effective time must not be in the future")
+var ErrRecordChanged = errors.New("record changed while the request was handled; retry it")
 
 // Implements method to get, create, and update record data.
 type RecordService interface {
 
 	// GetRecord will retrieve an record.
-	GetRecord(ctx context.Context, id int) (entity.Record, error)
+	GetRecord(ctx context.Context, id int64) (entity.Record, error)
 
 	// GetLastestRecordByID will retrieve the lastest record.
-	GetLastestRecordByID(ctx context.Context, id int) (entity.Record, error)
+	GetLastestRecordByID(ctx context.Context, id int64) (entity.Record, error)
 
 	// CreateRecord will insert a new record.
 	//
@@ -29,22 +33,29 @@ type RecordService interface {
 	// if the update[key] is null it will delete that key from the record's Map.
 	//
 	// UpdateRecord will error if id <= 0 or the record does not exist with that id.
-	UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error)
+	UpdateRecord(ctx context.Context, id int64, updates map[string]*string) (entity.Record, error)
 }
 
 // InMemoryRecordService is an in-memory implementation of RecordService.
+// Like the database, it keeps the records of each tenant apart.
 type InMemoryRecordService struct {
-	data map[int]entity.Record
+	data map[memoryKey]entity.Record
+}
+
+// memoryKey identifies a record of InMemoryRecordService.
+type memoryKey struct {
+	tenant string
+	id     int64
 }
 
 func NewInMemoryRecordService() InMemoryRecordService {
 	return InMemoryRecordService{
-		data: map[int]entity.Record{},
+		data: map[memoryKey]entity.Record{},
 	}
 }
 
-func (s *InMemoryRecordService) GetRecord(ctx context.Context, id int) (entity.Record, error) {
-	record := s.data[id]
+func (s *InMemoryRecordService) GetRecord(ctx context.Context, id int64) (entity.Record, error) {
+	record := s.data[memoryKey{TenantFromContext(ctx), id}]
 	if record.ID == 0 {
 		return entity.Record{}, ErrRecordDoesNotExist
 	}
@@ -59,17 +70,18 @@ func (s *InMemoryRecordService) CreateRecord(ctx context.Context, record entity.
 		return ErrRecordIDInvalid
 	}
 
-	existingRecord := s.data[id]
+	key := memoryKey{TenantFromContext(ctx), id}
+	existingRecord := s.data[key]
 	if existingRecord.ID != 0 {
 		return ErrRecordAlreadyExists
 	}
 
-	s.data[id] = record
+	s.data[key] = record
 	return nil
 }
 
-func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int, updates map[string]*string) (entity.Record, error) {
-	entry := s.data[id]
+func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int64, updates map[string]*string) (entity.Record, error) {
+	entry := s.data[memoryKey{TenantFromContext(ctx), id}]
 	if entry.ID == 0 {
 		return entity.Record{}, ErrRecordDoesNotExist
 	}
@@ -85,6 +97,6 @@ func (s *InMemoryRecordService) UpdateRecord(ctx context.Context, id int, update
 	return entry.Copy(), nil
 }
 
-func (s *InMemoryRecordService) GetLastestRecordByID(ctx context.Context, id int) (entity.Record, error) {
+func (s *InMemoryRecordService) GetLastestRecordByID(ctx context.Context, id int64) (entity.Record, error) {
 	return entity.Record{}, nil
 }
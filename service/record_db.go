@@ -2,23 +2,41 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/temelpa/timetravel/config"
 	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/fieldcrypt"
+	"github.com/temelpa/timetravel/receipt"
 	"github.com/temelpa/timetravel/storage"
 )
 
 type DatabaseService struct {
 	storage *storage.Storage
+
+	// signer and receiptKeys are set by SetReceiptKeys when receipts are
+	// enabled.
+	signer      *receipt.Signer
+	receiptKeys receipt.KeySet
+
+	// fields is set by SetEncryption when fields are encrypted at rest.
+	fields *fieldcrypt.Keyring
+
+	// tokens is set by SetTokenAuth when bearer tokens are accepted.
+	tokens *tokenAuth
+
+	// policies is set by SetAccessPolicies.
+	policies []config.AccessPolicy
 }
 
 func NewDatabaseService(storage *storage.Storage) DatabaseService {
 	return DatabaseService{storage: storage}
 }
 
-func (s *DatabaseService) GetAllRecordsByID(ctx context.Context, id int) ([]entity.Record, error) {
-	records, err := s.storage.GetRecordsByID(id)
+func (s *DatabaseService) GetAllRecordsByID(ctx context.Context, id int64) ([]entity.Record, error) {
+	records, err := s.store(ctx).GetRecordsByID(id)
 	if err != nil {
 		return []entity.Record{}, err
 	}
@@ -27,76 +45,166 @@ func (s *DatabaseService) GetAllRecordsByID(ctx context.Context, id int) ([]enti
 	}
 	var newRecords []entity.Record
 	for _, record := range records {
-		newRecords = append(newRecords, record.Copy()) // copy is necessary so modifations to the record don't change the stored record
+		newRecords = append(newRecords, s.reveal(ctx, record.Copy())) // copy is necessary so modifations to the record don't change the stored record
 	}
 	return newRecords, nil
 }
 
-func (s *DatabaseService) GetLastestRecordByID(ctx context.Context, id int) (entity.Record, error) {
-	record, err := s.storage.GetLastestRecordByID(id)
+func (s *DatabaseService) GetLastestRecordByID(ctx context.Context, id int64) (entity.Record, error) {
+	record, err := getRecord(s.store(ctx).GetLastestRecordByID(id))
+	return s.reveal(ctx, record), err
+}
+
+// getRecord wraps single-version storage lookups, translating a missing row
+// into ErrRecordDoesNotExist.
+func getRecord(record *entity.Record, err error) (entity.Record, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Record{}, ErrRecordDoesNotExist
+	}
 	if err != nil {
 		return entity.Record{}, err
 	}
-	if record.ID == 0 {
-		return entity.Record{}, ErrRecordDoesNotExist
-	}
 	return record.Copy(), nil
 }
 
-func (s *DatabaseService) GetRecordsByIDBetweenTimestamp(ctx context.Context, id int, startTime, endTime time.Time) ([]entity.Record, error) {
-	records, err := s.storage.GetRecordsByIDBetweenTimestamp(id, startTime, endTime)
+// GetRecordByVersion retrieves one specific version of a record.
+func (s *DatabaseService) GetRecordByVersion(ctx context.Context, id int64, version int) (entity.Record, error) {
+	record, err := getRecord(s.store(ctx).GetRecordByVersion(id, version))
+	return s.reveal(ctx, record), err
+}
+
+// GetRecordAsOf retrieves the version of a record that was current at asOf.
+func (s *DatabaseService) GetRecordAsOf(ctx context.Context, id int64, asOf time.Time) (entity.Record, error) {
+	record, err := getRecord(s.store(ctx).GetRecordAsOf(id, asOf))
+	return s.reveal(ctx, record), err
+}
+
+// GetRecordAsKnownAt retrieves the version of a record in effect at asOf
+// according to what had been recorded by knownAt.
+func (s *DatabaseService) GetRecordAsKnownAt(ctx context.Context, id int64, asOf, knownAt time.Time) (entity.Record, error) {
+	record, err := getRecord(s.store(ctx).GetRecordAsKnownAt(id, asOf, knownAt))
+	return s.reveal(ctx, record), err
+}
+
+// CorrectRecord applies patch retroactively at effectiveAt and recomputes the
+// later versions on top of it. The correction and every replayed version
+// must match the current schema of the record's type. The new versions are
+// returned, the correction first.
+func (s *DatabaseService) CorrectRecord(ctx context.Context, id int64, effectiveAt time.Time, patch entity.Patch) ([]entity.Record, error) {
+	if effectiveAt.After(time.Now()) {
+		return nil, ErrEffectiveTimeInFuture
+	}
+
+	patch, err := s.sealPatch(ctx, id, patch)
 	if err != nil {
-		return []entity.Record{}, err
+		return nil, err
 	}
-	if len(records) == 0 {
-		return []entity.Record{}, ErrRecordDoesNotExist
+	records, err := s.store(ctx).CorrectRecord(id, effectiveAt, patch, authorFromContext(ctx), s.typeCheck(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRecordDoesNotExist
+	}
+	if err != nil {
+		return nil, err
 	}
 	var newRecords []entity.Record
 	for _, record := range records {
-		newRecords = append(newRecords, record.Copy()) // copy is necessary so modifations to the record don't change the stored record
+		newRecords = append(newRecords, s.reveal(ctx, record.Copy()))
 	}
 	return newRecords, nil
 }
 
-func (s *DatabaseService) CreateRecord(ctx context.Context, record entity.Record) error {
-	id := record.ID
-	if id <= 0 {
-		return ErrRecordIDInvalid
+// RevertRecord appends a new version whose data equals target, linked back to
+// it so the history shows the rollback. Intermediate versions are kept. The
+// data must match the current schema of the record's type, which may have
+// changed since target was written.
+func (s *DatabaseService) RevertRecord(ctx context.Context, target entity.Record) (entity.Record, error) {
+	if err := s.typeCheck(ctx, target.ID)(target.Data); err != nil {
+		return entity.Record{}, err
 	}
-
-	dataBytes, err := json.Marshal(record.Data)
+	data, err := s.sealData(ctx, target.ID, target.Data)
 	if err != nil {
-		return err
+		return entity.Record{}, err
+	}
+	stored, err := s.store(ctx).InsertRecord(target.ID, data, storage.VersionMeta{
+		Operation:     entity.OperationRevert,
+		SourceVersion: target.Version,
+		Author:        authorFromContext(ctx),
+	})
+	if err != nil {
+		return entity.Record{}, err
 	}
+	return s.reveal(ctx, stored.Copy()), nil
+}
 
-	existingRecord, err := s.storage.InsertRecord(id, string(dataBytes))
+func (s *DatabaseService) GetRecordsByIDBetweenTimestamp(ctx context.Context, id int64, startTime, endTime time.Time) ([]entity.Record, error) {
+	records, err := s.store(ctx).GetRecordsByIDBetweenTimestamp(id, startTime, endTime)
 	if err != nil {
-		return err
+		return []entity.Record{}, err
 	}
-	if existingRecord != 0 {
-		return ErrRecordAlreadyExists
+	if len(records) == 0 {
+		return []entity.Record{}, ErrRecordDoesNotExist
+	}
+	var newRecords []entity.Record
+	for _, record := range records {
+		newRecords = append(newRecords, s.reveal(ctx, record.Copy())) // copy is necessary so modifations to the record don't change the stored record
 	}
-	return nil
+	return newRecords, nil
 }
 
-func (s *DatabaseService) UpdateRecord(ctx context.Context, id int, updates map[string]string) (entity.Record, error) {
-	updateBytes, err := json.Marshal(updates)
+// CreateRecord stores record as a new version and returns it as stored. The
+// record's Operation is kept as version metadata and the caller recorded as
+// its author. With the create operation the record must not exist yet;
+// otherwise ErrRecordAlreadyExists is returned. If record.Seq is set, the
+// data was derived from that stored version, and ErrRecordChanged is
+// returned if another version was stored since. A record with a type, or
+// given one by recordType, must match the current schema of the type;
+// otherwise a *ValidationError is returned.
+func (s *DatabaseService) CreateRecord(ctx context.Context, record entity.Record, recordType string) (entity.Record, error) {
+	id := record.ID
+	if id <= 0 {
+		return entity.Record{}, ErrRecordIDInvalid
+	}
+	if id > entity.MaxRecordID {
+		return entity.Record{}, ErrRecordIDTooLarge
+	}
+
+	// callers without decrypt access send unchanged values encrypted
+	recordType, err := s.checkType(ctx, id, recordType, s.plaintext(ctx, record.Copy()).Data)
 	if err != nil {
 		return entity.Record{}, err
 	}
-
-	entry, err := s.storage.UpdateRecord(id, string(updateBytes))
+	data, err := s.sealData(ctx, id, record.Data)
 	if err != nil {
 		return entity.Record{}, err
 	}
-	if entry == 0 {
-		return entity.Record{}, ErrRecordDoesNotExist
+	stored, err := s.store(ctx).InsertRecord(id, data, storage.VersionMeta{
+		Operation: record.Operation,
+		Author:    authorFromContext(ctx),
+		Type:      recordType,
+		BaseSeq:   record.Seq,
+	})
+	if errors.Is(err, storage.ErrRecordExists) {
+		return entity.Record{}, ErrRecordAlreadyExists
+	}
+	if errors.Is(err, storage.ErrRecordChanged) {
+		return entity.Record{}, ErrRecordChanged
 	}
+	if err != nil {
+		return entity.Record{}, typeError(err)
+	}
+	return s.reveal(ctx, stored.Copy()), nil
+}
 
-	updatedEntry, err := s.storage.GetLastestRecordByID(id)
+// CreateNewRecord stores data as the first version of a record with an id
+// assigned by the server, as CreateRecord does for a given id. The access
+// policies must allow the caller to write the new id.
+func (s *DatabaseService) CreateNewRecord(ctx context.Context, data map[string]interface{}, recordType string) (entity.Record, error) {
+	id, err := s.store(ctx).NextRecordID()
 	if err != nil {
 		return entity.Record{}, err
 	}
-
-	return updatedEntry.Copy(), nil
+	if err := s.Authorize(ctx, AccessWrite, id); err != nil {
+		return entity.Record{}, err
+	}
+	return s.CreateRecord(ctx, entity.Record{ID: id, Data: data, Operation: entity.OperationCreate}, recordType)
 }
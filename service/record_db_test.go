@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+func TestCreateRecordRefusesStaleBase(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	if _, err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: map[string]interface{}{"n": "1"}, Operation: entity.OperationCreate}, ""); err != nil {
+		t.Fatal(err)
+	}
+	read, err := s.GetLastestRecordByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two patches of the version read; only the first may be stored
+	for i, value := range []string{"2", "3"} {
+		_, err := s.CreateRecord(ctx, entity.Record{ID: 1, Seq: read.Seq, Data: map[string]interface{}{"n": value}, Operation: entity.OperationUpdate}, "")
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && !errors.Is(err, ErrRecordChanged) {
+			t.Fatalf("patching a version that is no longer current: %v", err)
+		}
+	}
+	latest, err := s.GetLastestRecordByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Data["n"] != "2" {
+		t.Fatalf("latest version holds %v", latest.Data["n"])
+	}
+}
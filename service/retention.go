@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// defaultCompactionInterval is used when the retention config sets none.
+const defaultCompactionInterval = time.Hour
+
+// GetRecordTags returns the tags of a record.
+func (s *DatabaseService) GetRecordTags(ctx context.Context, id int64) ([]string, error) {
+	return s.store(ctx).GetRecordTags(id)
+}
+
+// SetRecordTags replaces the tags of a record. Tags select the retention
+// policies that apply to it.
+func (s *DatabaseService) SetRecordTags(ctx context.Context, id int64, tags []string) error {
+	if id <= 0 {
+		return ErrRecordIDInvalid
+	}
+	return s.store(ctx).SetRecordTags(id, tags)
+}
+
+// ApplyRetention runs each policy once, in every tenant, against the history
+// as of now and returns how many versions were removed. Every collapsed
+// version is logged.
+func (s *DatabaseService) ApplyRetention(ctx context.Context, policies []config.RetentionPolicy, now time.Time) (int, error) {
+	tenants, err := s.storage.GetTenants()
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, policy := range policies {
+		for _, tenant := range tenants {
+			if err := ctx.Err(); err != nil {
+				return total, err
+			}
+			removed, err := s.storage.WithTenant(tenant).CompactRecords(storage.RetentionRule{
+				Name:   policy.Name,
+				MinID:  policy.MinID,
+				MaxID:  policy.MaxID,
+				Tag:    policy.Tag,
+				Before: now.Add(-time.Duration(policy.OlderThan)),
+				Period: strings.TrimPrefix(policy.Keep, "last_per_"),
+			})
+			if err != nil {
+				return total, fmt.Errorf("retention policy %s: %w", policy.Name, err)
+			}
+
+			versions := map[int64][]int{}
+			for _, record := range removed {
+				versions[record.ID] = append(versions[record.ID], record.Version)
+			}
+			ids := make([]int64, 0, len(versions))
+			for id := range versions {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			for _, id := range ids {
+				log.Printf("retention policy %s: collapsed record %d of tenant %q versions %v", policy.Name, id, tenant, versions[id])
+			}
+			total += len(removed)
+		}
+	}
+	return total, nil
+}
+
+// Compactor applies retention policies in the background.
+type Compactor struct {
+	service   *DatabaseService
+	retention config.RetentionConfig
+}
+
+func NewCompactor(service *DatabaseService, retention config.RetentionConfig) *Compactor {
+	return &Compactor{service: service, retention: retention}
+}
+
+// Run applies the policies every interval until ctx is cancelled.
+func (c *Compactor) Run(ctx context.Context) {
+	interval := time.Duration(c.retention.Interval)
+	if interval <= 0 {
+		interval = defaultCompactionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		removed, err := c.service.ApplyRetention(ctx, c.retention.Policies, time.Now())
+		if err != nil {
+			log.Printf("error: compaction: %v", err)
+		} else {
+			log.Printf("compaction removed %d versions", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
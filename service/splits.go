@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrInvalidSplit = errors.New("a split needs one or more keys that the source version has")
+var ErrSplitNotLatest = errors.New("keys can only be moved out of the latest version; set keep to copy them from an older one")
+
+// SplitRecord creates a record with an id assigned by the server from keys,
+// top-level keys of version version of sourceID, or of its latest version if
+// version is 0. Unless keep is set, the keys move: they are removed from
+// sourceID in a new version, which must still match its type, so they can
+// only be moved out of the latest version. Both records remember the split,
+// and the field history of the new record follows the split keys back into
+// sourceID. The caller must be allowed to write the new id. If the source
+// changes while the keys move, ErrRecordChanged is returned. The new version
+// of the source is returned after the new record, or a zero record if the
+// keys were kept.
+func (s *DatabaseService) SplitRecord(ctx context.Context, sourceID int64, version int, keys []string, keep bool) (entity.Split, entity.Record, entity.Record, error) {
+	if len(keys) == 0 {
+		return entity.Split{}, entity.Record{}, entity.Record{}, ErrInvalidSplit
+	}
+	// values are copied between records, so encrypted ones are split in
+	// plaintext whatever the caller may read
+	latest, err := getRecord(s.store(ctx).GetLastestRecordByID(sourceID))
+	if err != nil {
+		return entity.Split{}, entity.Record{}, entity.Record{}, err
+	}
+	latest = s.plaintext(ctx, latest)
+	source := latest
+	if version != 0 && version != latest.Version {
+		if !keep {
+			return entity.Split{}, entity.Record{}, entity.Record{}, ErrSplitNotLatest
+		}
+		source, err = getRecord(s.store(ctx).GetRecordByVersion(sourceID, version))
+		if err != nil {
+			return entity.Split{}, entity.Record{}, entity.Record{}, err
+		}
+		source = s.plaintext(ctx, source)
+	}
+
+	data := map[string]interface{}{}
+	for _, key := range keys {
+		value, ok := source.Data[key]
+		if !ok {
+			return entity.Split{}, entity.Record{}, entity.Record{}, ErrInvalidSplit
+		}
+		data[key] = entity.CopyValue(value)
+	}
+	var remaining map[string]interface{}
+	if !keep {
+		remaining = entity.CopyData(latest.Data)
+		for _, key := range keys {
+			delete(remaining, key)
+		}
+		if _, err := s.checkType(ctx, sourceID, "", remaining); err != nil {
+			return entity.Split{}, entity.Record{}, entity.Record{}, err
+		}
+		remaining, err = s.sealData(ctx, sourceID, remaining)
+		if err != nil {
+			return entity.Split{}, entity.Record{}, entity.Record{}, err
+		}
+	}
+
+	newID, err := s.store(ctx).NextRecordID()
+	if err != nil {
+		return entity.Split{}, entity.Record{}, entity.Record{}, err
+	}
+	if err := s.Authorize(ctx, AccessWrite, newID); err != nil {
+		return entity.Split{}, entity.Record{}, entity.Record{}, err
+	}
+	data, err = s.sealData(ctx, newID, data)
+	if err != nil {
+		return entity.Split{}, entity.Record{}, entity.Record{}, err
+	}
+
+	split, created, changed, err := s.store(ctx).SplitRecord(entity.Split{
+		SourceID:      sourceID,
+		SourceVersion: source.Version,
+		NewID:         newID,
+		Keys:          keys,
+		SplitBy:       ActorFromContext(ctx),
+	}, data, remaining, latest.Seq, storage.VersionMeta{
+		Operation: entity.OperationSplit,
+		Author:    authorFromContext(ctx),
+	})
+	if errors.Is(err, storage.ErrRecordChanged) {
+		return entity.Split{}, entity.Record{}, entity.Record{}, ErrRecordChanged
+	}
+	if errors.Is(err, storage.ErrRecordExists) {
+		return entity.Split{}, entity.Record{}, entity.Record{}, ErrRecordAlreadyExists
+	}
+	if err != nil {
+		return entity.Split{}, entity.Record{}, entity.Record{}, err
+	}
+	var sourceRecord entity.Record
+	if changed != nil {
+		sourceRecord = s.reveal(ctx, changed.Copy())
+	}
+	return *split, s.reveal(ctx, created.Copy()), sourceRecord, nil
+}
+
+// GetSplits lists the splits of a record into others and the split it was
+// created by, so its lineage can be followed both ways.
+func (s *DatabaseService) GetSplits(ctx context.Context, id int64) ([]entity.Split, error) {
+	return s.store(ctx).GetSplits(id)
+}
+
+// splitOrigin returns the split a record was created by, or nil.
+func (s *DatabaseService) splitOrigin(ctx context.Context, id int64) (*entity.Split, error) {
+	split, err := s.store(ctx).GetSplitOrigin(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return split, err
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/temelpa/timetravel/config"
+	"github.com/temelpa/timetravel/entity"
+)
+
+// splitSource writes two versions of record 10 that differ in "a".
+func splitSource(t *testing.T, s *DatabaseService) {
+	t.Helper()
+	for i, data := range []map[string]interface{}{
+		{"a": json.Number("1"), "b": json.Number("1")},
+		{"a": json.Number("2"), "b": json.Number("1")},
+	} {
+		operation := entity.OperationUpdate
+		if i == 0 {
+			operation = entity.OperationCreate
+		}
+		if _, err := s.CreateRecord(tenantContext(""), entity.Record{ID: 10, Data: data, Operation: operation}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSplitRecordMovesOnlyLatestKeys(t *testing.T) {
+	s := newTestService(t)
+	ctx := tenantContext("")
+	splitSource(t, s)
+
+	if _, _, _, err := s.SplitRecord(ctx, 10, 1, []string{"a"}, false); !errors.Is(err, ErrSplitNotLatest) {
+		t.Fatalf("moving keys out of an older version: %v", err)
+	}
+	_, kept, _, err := s.SplitRecord(ctx, 10, 1, []string{"a"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept.Data["a"] != json.Number("1") {
+		t.Fatalf("keys copied from version 1 hold %v", kept.Data["a"])
+	}
+	split, moved, source, err := s.SplitRecord(ctx, 10, 2, []string{"a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !split.Moved || moved.Data["a"] != json.Number("2") {
+		t.Fatalf("keys moved from the latest version hold %v", moved.Data["a"])
+	}
+	if _, ok := source.Data["a"]; ok || source.Data["b"] != json.Number("1") {
+		t.Fatalf("source after the split holds %v", source.Data)
+	}
+}
+
+func TestFieldHistoryLeavesOutUnreadableSource(t *testing.T) {
+	s := newTestService(t)
+	splitSource(t, s)
+	split, _, _, err := s.SplitRecord(tenantContext(""), 10, 0, []string{"a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAccessPolicies([]config.AccessPolicy{{Name: "new", Roles: []string{"agent"}, MinID: split.NewID}})
+
+	changes, err := s.GetFieldHistory(tenantContext(""), split.NewID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[1].RecordID != 10 {
+		t.Fatalf("admin history of a split key: %+v", changes)
+	}
+
+	agent := WithPrincipal(tenantContext(""), entity.Principal{Subject: "agent", Roles: []string{"agent"}, Scopes: []string{"read"}})
+	changes, err = s.GetFieldHistory(agent, split.NewID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].RecordID != 0 {
+		t.Fatalf("history of a split key for a caller that may not read the source: %+v", changes)
+	}
+}
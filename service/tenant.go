@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+
+	"github.com/temelpa/timetravel/storage"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose requests only see the records of
+// tenant. Authenticated requests get the tenant of their principal.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant a request acts for, or the default
+// tenant "".
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// store returns the storage of the tenant the request acts for. Every lookup
+// and change on behalf of a request goes through it, so one tenant can never
+// reach the records of another.
+func (s *DatabaseService) store(ctx context.Context) *storage.Storage {
+	return s.storage.WithTenant(TenantFromContext(ctx))
+}
@@ -0,0 +1,450 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/receipt"
+	"github.com/temelpa/timetravel/storage"
+)
+
+// twoTenants returns a service in which tenants acme and other both have
+// records 1 and 2, each version holding the name of its tenant as owner.
+// Record 1 of other is written first and has one version; record 1 of acme
+// has two.
+func twoTenants(t *testing.T) *DatabaseService {
+	t.Helper()
+	return twoTenantsAt(t, filepath.Join(t.TempDir(), "test.db"))
+}
+
+// twoTenantsAt is twoTenants over the database at path.
+func twoTenantsAt(t *testing.T, path string) *DatabaseService {
+	t.Helper()
+	s := newTestServiceAt(t, path)
+	write := func(tenant string, id int64, operation string, n string) {
+		t.Helper()
+		data := map[string]interface{}{"owner": tenant, "n": n}
+		if _, err := s.CreateRecord(tenantContext(tenant), entity.Record{ID: id, Data: data, Operation: operation}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("other", 1, entity.OperationCreate, "1")
+	write("other", 2, entity.OperationCreate, "1")
+	write("acme", 1, entity.OperationCreate, "1")
+	write("acme", 1, entity.OperationUpdate, "2")
+	write("acme", 2, entity.OperationCreate, "1")
+	return s
+}
+
+// ownedBy fails the test if any record is not owned by tenant.
+func ownedBy(t *testing.T, tenant string, records ...entity.Record) {
+	t.Helper()
+	for _, record := range records {
+		if record.Data["owner"] != tenant {
+			t.Fatalf("%s read record %d version %d of %v", tenant, record.ID, record.Version, record.Data["owner"])
+		}
+	}
+}
+
+func TestTenantsReadOnlyTheirOwnVersions(t *testing.T) {
+	s := twoTenants(t)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	latest, err := s.GetLastestRecordByID(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", latest)
+	if latest.Version != 1 {
+		t.Fatalf("latest version of other's record is %d", latest.Version)
+	}
+	acmeVersions, err := s.GetAllRecordsByID(acme, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "acme", acmeVersions...)
+	if len(acmeVersions) != 2 {
+		t.Fatalf("acme has %d versions of record 1", len(acmeVersions))
+	}
+	otherVersions, err := s.GetAllRecordsByID(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", otherVersions...)
+
+	if _, err := s.GetRecordByVersion(other, 1, 2); !errors.Is(err, ErrRecordDoesNotExist) {
+		t.Fatalf("other read version 2, which only acme has: %v", err)
+	}
+	asOf := acmeVersions[0].EffectiveAt
+	for _, read := range []func(ctx context.Context) (entity.Record, error){
+		func(ctx context.Context) (entity.Record, error) { return s.GetRecordAsOf(ctx, 1, asOf) },
+		func(ctx context.Context) (entity.Record, error) {
+			return s.GetRecordAsKnownAt(ctx, 1, asOf, time.Now())
+		},
+	} {
+		record, err := read(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownedBy(t, "other", record)
+		record, err = read(acme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownedBy(t, "acme", record)
+	}
+	between, err := s.GetRecordsByIDBetweenTimestamp(other, 1, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", between...)
+
+	changes, err := s.GetFieldHistory(other, 1, "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Value != "other" {
+		t.Fatalf("other's history of owner: %+v", changes)
+	}
+	if _, err := s.DiffVersions(other, 1, 1, 2, ""); !errors.Is(err, ErrRecordDoesNotExist) {
+		t.Fatalf("other diffed against acme's version 2: %v", err)
+	}
+}
+
+func TestTenantsWriteOnlyTheirOwnRecords(t *testing.T) {
+	s := twoTenants(t)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	if _, err := s.CreateRecord(acme, entity.Record{ID: 2, Data: map[string]interface{}{"owner": "acme"}, Operation: entity.OperationCreate}, ""); !errors.Is(err, ErrRecordAlreadyExists) {
+		t.Fatalf("acme created its own record 2 again: %v", err)
+	}
+	versions, err := s.GetAllRecordsByID(acme, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RevertRecord(acme, versions[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CorrectRecord(acme, 1, versions[0].EffectiveAt, entity.Patch{"n": "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteRecord(acme, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.GetAllRecordsByID(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", records...)
+	if len(records) != 1 || records[0].Data["n"] != "1" {
+		t.Fatalf("acme's writes changed other's record 1: %+v", records)
+	}
+	record, err := s.GetLastestRecordByID(other, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.DeletedAt.IsZero() {
+		t.Fatal("acme deleted other's record 2")
+	}
+
+	// ids are assigned per tenant
+	for _, ctx := range []context.Context{acme, other} {
+		created, err := s.CreateNewRecord(ctx, map[string]interface{}{"owner": TenantFromContext(ctx)}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID != 3 {
+			t.Fatalf("%s was assigned id %d", TenantFromContext(ctx), created.ID)
+		}
+	}
+	for _, ctx := range []context.Context{acme, other} {
+		record, err := s.GetLastestRecordByID(ctx, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownedBy(t, TenantFromContext(ctx), record)
+	}
+}
+
+func TestTenantsExportAndImportOnlyTheirOwnRecords(t *testing.T) {
+	s := twoTenants(t)
+	acme, other, third := tenantContext("acme"), tenantContext("other"), tenantContext("third")
+
+	var export bytes.Buffer
+	count, err := s.ExportRecords(acme, storage.ExportFilter{}, &export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || strings.Contains(export.String(), `"other"`) {
+		t.Fatalf("acme exported %d versions: %s", count, export.String())
+	}
+
+	imported, _, err := s.ImportRecords(third, bytes.NewReader(export.Bytes()))
+	if err != nil || imported != 3 {
+		t.Fatalf("third imported %d versions: %v", imported, err)
+	}
+	records, err := s.GetAllRecordsByID(third, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "acme", records...)
+	records, err = s.GetAllRecordsByID(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", records...)
+	if len(records) != 1 {
+		t.Fatalf("importing into third added versions to other: %+v", records)
+	}
+
+	// other's own record 1 version 1 holds other data than acme's
+	if _, _, err := s.ImportRecords(other, bytes.NewReader(export.Bytes())); !errors.Is(err, ErrImportConflict) {
+		t.Fatalf("other imported acme's versions over its own: %v", err)
+	}
+}
+
+func TestTenantsKeepTagsHoldsAliasesAndLinksApart(t *testing.T) {
+	s := twoTenants(t)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	if err := s.SetRecordTags(acme, 1, []string{"draft_quote"}); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := s.GetRecordTags(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Fatalf("other sees acme's tags: %v", tags)
+	}
+
+	hold, err := s.PlaceLegalHold(acme, 1, "case-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	holds, err := s.GetLegalHolds(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holds) != 0 {
+		t.Fatalf("other sees acme's holds: %+v", holds)
+	}
+	if _, err := s.LiftLegalHold(other, hold.ID); !errors.Is(err, ErrHoldNotActive) {
+		t.Fatalf("other lifted acme's hold: %v", err)
+	}
+	if err := s.DeleteRecord(acme, 1); !errors.Is(err, ErrRecordOnHold) {
+		t.Fatalf("acme deleted its held record: %v", err)
+	}
+	if err := s.DeleteRecord(other, 1); err != nil {
+		t.Fatalf("acme's hold kept other from deleting its record: %v", err)
+	}
+
+	if _, err := s.AddAlias(acme, 1, "crm", "c-1", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResolveAlias(other, "crm", "c-1", time.Now()); !errors.Is(err, ErrAliasNotFound) {
+		t.Fatalf("other resolved acme's alias: %v", err)
+	}
+	if _, err := s.AddAlias(other, 2, "crm", "c-1", time.Time{}); err != nil {
+		t.Fatalf("acme's alias kept other from using the key: %v", err)
+	}
+	for tenant, want := range map[string]int64{"acme": 1, "other": 2} {
+		id, err := s.ResolveAlias(tenantContext(tenant), "crm", "c-1", time.Now())
+		if err != nil || id != want {
+			t.Fatalf("%s resolved crm:c-1 to %d: %v", tenant, id, err)
+		}
+	}
+
+	link, err := s.AddLink(acme, entity.Link{FromID: 1, ToID: 2, Type: "employs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.GetLinks(other, 1, storage.LinkFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("other sees acme's links: %+v", links)
+	}
+	if _, err := s.EndLink(other, 1, link.ID, time.Time{}); !errors.Is(err, ErrLinkNotActive) {
+		t.Fatalf("other ended acme's link: %v", err)
+	}
+}
+
+func TestTenantsKeepMergesAndSplitsApart(t *testing.T) {
+	s := twoTenants(t)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	if _, _, err := s.MergeRecord(acme, 2, 1, entity.MergeKeepInto, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	into, err := s.MergedInto(other, 2)
+	if err != nil || into != 0 {
+		t.Fatalf("other's record 2 is merged into %d: %v", into, err)
+	}
+	resolved, err := s.ResolveMerges(other, 2, time.Now(), time.Now())
+	if err != nil || resolved != 2 {
+		t.Fatalf("other's record 2 resolves to %d: %v", resolved, err)
+	}
+	merges, err := s.GetMerges(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merges) != 0 {
+		t.Fatalf("other sees acme's merges: %+v", merges)
+	}
+	if _, _, err := s.MergeRecord(other, 2, 1, entity.MergeKeepInto, time.Time{}); err != nil {
+		t.Fatalf("acme's merge kept other from merging its own records: %v", err)
+	}
+
+	split, _, _, err := s.SplitRecord(acme, 1, 0, []string{"n"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	splits, err := s.GetSplits(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(splits) != 0 {
+		t.Fatalf("other sees acme's splits: %+v", splits)
+	}
+	record, err := s.GetLastestRecordByID(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedBy(t, "other", record)
+	if _, ok := record.Data["n"]; !ok {
+		t.Fatal("acme's split moved a key out of other's record")
+	}
+	if _, err := s.GetLastestRecordByID(other, split.NewID); !errors.Is(err, ErrRecordDoesNotExist) {
+		t.Fatalf("other reads the record split off acme's: %v", err)
+	}
+}
+
+func TestTenantsKeepAuditAndAccessLogsApart(t *testing.T) {
+	s := twoTenants(t)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	if _, err := s.PlaceLegalHold(acme, 1, "case-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LogAccess(acme, entity.AccessEntry{RecordID: 1, Request: "GET /api/v2/records/1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	audit, err := s.GetAuditEntries(acme, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit) == 0 {
+		t.Fatal("acme's hold is not in its audit trail")
+	}
+	audit, err = s.GetAuditEntries(other, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range audit {
+		if entry.Actor != ActorFromContext(other) {
+			t.Fatalf("other sees acme's audit entry: %+v", entry)
+		}
+	}
+
+	accesses, err := s.GetAccessEntries(acme, storage.AccessFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accesses) != 1 {
+		t.Fatalf("acme's access log holds %d entries", len(accesses))
+	}
+	accesses, err = s.GetAccessEntries(other, storage.AccessFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accesses) != 0 {
+		t.Fatalf("other sees acme's access log: %+v", accesses)
+	}
+}
+
+func TestTenantsReceiptsProveOnlyTheirOwnRecords(t *testing.T) {
+	s := twoTenants(t)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := receipt.NewSigner("test", key)
+	keys := receipt.KeySet{"test": signer.PublicKey()}
+	s.SetReceiptKeys(signer, keys)
+
+	// both tenants have version 1 of record 1
+	issued, err := s.IssueReceipt(tenantContext("acme"), 1, 1, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statement, err := receipt.Verify(issued, keys, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.Tenant != "acme" || statement.Record.ID != 1 || statement.Record.Version != 1 {
+		t.Fatalf("acme's receipt attests %q record %d version %d", statement.Tenant, statement.Record.ID, statement.Record.Version)
+	}
+	if _, err := receipt.Verify(issued, keys, "other"); !errors.Is(err, receipt.ErrOtherTenant) {
+		t.Fatalf("acme's receipt verified as proof for other: %v", err)
+	}
+}
+
+func TestTenantsVerifyOnlyTheirOwnChains(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := twoTenantsAt(t, path)
+	acme, other := tenantContext("acme"), tenantContext("other")
+
+	for _, tenant := range []struct {
+		ctx     context.Context
+		checked int
+	}{{acme, 3}, {other, 2}} {
+		report, err := s.VerifyChain(tenant.ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK || report.Checked != tenant.checked {
+			t.Fatalf("%s verified %d versions: %+v", TenantFromContext(tenant.ctx), report.Checked, report.Broken)
+		}
+	}
+
+	// replace acme's record 2 with other's, which is sealed just as well
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`DELETE FROM records WHERE tenant = 'acme' AND id = 2`,
+		`UPDATE records SET tenant = 'acme' WHERE tenant = 'other' AND id = 2`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := s.VerifyChain(acme, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || report.Broken.ID != 2 {
+		t.Fatalf("a version moved from other verified in acme: %+v", report)
+	}
+	report, err = s.VerifyChain(other, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK || report.Checked != 1 {
+		t.Fatalf("other verified %d versions: %+v", report.Checked, report.Broken)
+	}
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+	"github.com/temelpa/timetravel/schema"
+	"github.com/temelpa/timetravel/storage"
+)
+
+var ErrUnknownType = errors.New("record type does not exist")
+var ErrInvalidTypeName = errors.New("type names may only contain letters, digits, '-' and '_'")
+var ErrTypeMismatch = errors.New("record already has a different type")
+
+var typeName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidationError is returned when the data of a new version does not match
+// the schema of its record type.
+type ValidationError struct {
+	Type          string
+	SchemaVersion int
+	Errors        []entity.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("record does not match version %d of the %s schema", e.SchemaVersion, e.Type)
+}
+
+// RegisterRecordType stores the next version of the named type. raw is
+// either a JSON Schema or {"schema": ..., "migration": [...]}, where the
+// migration upgrades data written under the previous version. Invalid
+// schemas are refused with an error wrapping schema.ErrInvalidSchema and
+// invalid migrations with entity.ErrInvalidMigration.
+func (s *DatabaseService) RegisterRecordType(ctx context.Context, name string, raw []byte) (entity.RecordType, error) {
+	if !typeName.MatchString(name) {
+		return entity.RecordType{}, ErrInvalidTypeName
+	}
+	raw, migration, err := splitRegistration(raw)
+	if err != nil {
+		return entity.RecordType{}, err
+	}
+	if _, err := schema.Compile(raw); err != nil {
+		return entity.RecordType{}, err
+	}
+	if len(migration) > 0 {
+		versions, err := s.store(ctx).GetRecordTypeVersions(name)
+		if err != nil {
+			return entity.RecordType{}, err
+		}
+		if len(versions) == 0 {
+			return entity.RecordType{}, fmt.Errorf("%w: the first version of a type has nothing to migrate from", entity.ErrInvalidMigration)
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		return entity.RecordType{}, err
+	}
+	recordType, err := s.store(ctx).AddRecordType(name, compact.String(), migration, ActorFromContext(ctx))
+	if err != nil {
+		return entity.RecordType{}, err
+	}
+	return *recordType, nil
+}
+
+// splitRegistration separates the schema of a type registration from its
+// migration. A bare schema cannot have a top-level "schema" member, as that
+// is not a supported keyword, so the two forms cannot be confused.
+func splitRegistration(raw []byte) (json.RawMessage, entity.Migration, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil || members["schema"] == nil {
+		return raw, nil, nil
+	}
+	var migration entity.Migration
+	for key, member := range members {
+		switch key {
+		case "schema":
+		case "migration":
+			var err error
+			migration, err = entity.DecodeMigration(member)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: unexpected member %q next to schema", schema.ErrInvalidSchema, key)
+		}
+	}
+	return members["schema"], migration, nil
+}
+
+// GetRecordTypes returns the latest version of every record type.
+func (s *DatabaseService) GetRecordTypes(ctx context.Context) ([]entity.RecordType, error) {
+	return s.store(ctx).GetRecordTypes()
+}
+
+// GetRecordTypeVersions returns every version of the named type, oldest
+// first.
+func (s *DatabaseService) GetRecordTypeVersions(ctx context.Context, name string) ([]entity.RecordType, error) {
+	versions, err := s.store(ctx).GetRecordTypeVersions(name)
+	if err == nil && len(versions) == 0 {
+		return nil, ErrUnknownType
+	}
+	return versions, err
+}
+
+// checkType validates data, the new data of record id, against the current
+// schema of its type and returns that type. requested, if set, must be the
+// type the record already has, or is given to a record that has none.
+func (s *DatabaseService) checkType(ctx context.Context, id int64, requested string, data map[string]interface{}) (string, error) {
+	recordType, err := s.store(ctx).GetTypeOf(id)
+	if err != nil {
+		return "", err
+	}
+	if requested != "" && recordType != "" && requested != recordType {
+		return "", fmt.Errorf("%w: %s", ErrTypeMismatch, recordType)
+	}
+	if recordType == "" {
+		recordType = requested
+	}
+	if recordType == "" {
+		return "", nil
+	}
+
+	current, err := s.store(ctx).GetRecordTypeAt(recordType, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("%w: %s", ErrUnknownType, recordType)
+	}
+	if err != nil {
+		return "", err
+	}
+	errs, err := validateData(current, data)
+	if err != nil {
+		return "", err
+	}
+	if len(errs) > 0 {
+		return "", &ValidationError{Type: recordType, SchemaVersion: current.Version, Errors: errs}
+	}
+	return recordType, nil
+}
+
+// typeCheck returns a check of new data of record id against the current
+// schema of its type, for changes whose data is only known as stored.
+// Encrypted values are checked in plaintext.
+func (s *DatabaseService) typeCheck(ctx context.Context, id int64) func(data map[string]interface{}) error {
+	return func(data map[string]interface{}) error {
+		record := s.plaintext(ctx, entity.Record{ID: id, Data: entity.CopyData(data)})
+		_, err := s.checkType(ctx, id, "", record.Data)
+		return err
+	}
+}
+
+// checkHistoricalType validates record, a version written elsewhere such as
+// an imported one, against the schema of its type that applied when it was
+// written, as ValidateVersion does. types caches the type of each record.
+func (s *DatabaseService) checkHistoricalType(ctx context.Context, record *entity.Record, types map[int64]string) error {
+	recordType, ok := types[record.ID]
+	if !ok {
+		var err error
+		recordType, err = s.store(ctx).GetTypeOf(record.ID)
+		if err != nil {
+			return err
+		}
+		types[record.ID] = recordType
+	}
+	if recordType == "" {
+		return nil
+	}
+	writtenAt := record.CreatedAt
+	if writtenAt.IsZero() {
+		writtenAt = time.Now()
+	}
+	applied, err := s.store(ctx).GetRecordTypeAt(recordType, writtenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil // written before its type had a schema
+	}
+	if err != nil {
+		return err
+	}
+	plain := s.plaintext(ctx, entity.Record{ID: record.ID, Data: entity.CopyData(record.Data)})
+	errs, err := validateData(applied, plain.Data)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Type: recordType, SchemaVersion: applied.Version, Errors: errs}
+	}
+	return nil
+}
+
+// ValidateVersion checks a stored version of a record against the schema
+// version of its type that applied when the version was written, so old
+// versions are not judged by rules introduced after them. Encrypted fields
+// are checked in plaintext; the result only reports paths.
+func (s *DatabaseService) ValidateVersion(ctx context.Context, id int64, version int) (entity.Validation, error) {
+	record, err := getRecord(s.store(ctx).GetRecordByVersion(id, version))
+	if err != nil {
+		return entity.Validation{}, err
+	}
+	validation := entity.Validation{ID: id, Version: version, Valid: true, Errors: []entity.FieldError{}}
+	validation.Type, err = s.store(ctx).GetTypeOf(id)
+	if err != nil || validation.Type == "" {
+		return validation, err
+	}
+
+	applied, err := s.store(ctx).GetRecordTypeAt(validation.Type, record.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return validation, nil // written before its type had a schema
+	}
+	if err != nil {
+		return entity.Validation{}, err
+	}
+	record = s.plaintext(ctx, record)
+	validation.SchemaVersion = applied.Version
+	validation.Errors, err = validateData(applied, record.Data)
+	if err != nil {
+		return entity.Validation{}, err
+	}
+	validation.Valid = len(validation.Errors) == 0
+	return validation, nil
+}
+
+// UpgradeRecords returns records with the data of each version upgraded to
+// the latest schema of its type, by running the migrations of the schema
+// versions registered after the version was written. Upgraded versions say
+// so in Upgrade and leave out their patch, which describes the data as
+// stored. The stored versions are not changed.
+func (s *DatabaseService) UpgradeRecords(ctx context.Context, records []entity.Record) ([]entity.Record, error) {
+	recordTypes := map[int64]string{}
+	versions := map[string][]entity.RecordType{}
+	upgraded := make([]entity.Record, 0, len(records))
+	for _, record := range records {
+		recordType, ok := recordTypes[record.ID]
+		if !ok {
+			var err error
+			recordType, err = s.store(ctx).GetTypeOf(record.ID)
+			if err != nil {
+				return nil, err
+			}
+			recordTypes[record.ID] = recordType
+		}
+		if recordType == "" {
+			upgraded = append(upgraded, record)
+			continue
+		}
+		if _, ok := versions[recordType]; !ok {
+			var err error
+			versions[recordType], err = s.store(ctx).GetRecordTypeVersions(recordType)
+			if err != nil {
+				return nil, err
+			}
+		}
+		upgraded = append(upgraded, upgradeRecord(record, recordType, versions[recordType]))
+	}
+	return upgraded, nil
+}
+
+// upgradeRecord migrates record from the schema version that applied when it
+// was written through the later ones in versions, oldest first.
+func upgradeRecord(record entity.Record, recordType string, versions []entity.RecordType) entity.Record {
+	applied := 0
+	for _, version := range versions {
+		if !version.CreatedAt.After(record.CreatedAt) {
+			applied = version.Version
+		}
+	}
+	if len(versions) == 0 || applied == versions[len(versions)-1].Version {
+		return record
+	}
+	for _, version := range versions {
+		if version.Version > applied {
+			record.Data = version.Migration.Apply(record.Data)
+		}
+	}
+	record.Patch = nil
+	record.Upgrade = &entity.SchemaUpgrade{
+		Type: recordType,
+		From: applied,
+		To:   versions[len(versions)-1].Version,
+	}
+	return record
+}
+
+func validateData(recordType *entity.RecordType, data map[string]interface{}) ([]entity.FieldError, error) {
+	compiled, err := schema.Compile(recordType.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("%s schema version %d: %w", recordType.Name, recordType.Version, err)
+	}
+	return compiled.Validate(data), nil
+}
+
+// typeError translates storage type errors into service errors.
+func typeError(err error) error {
+	if errors.Is(err, storage.ErrTypeConflict) {
+		return ErrTypeMismatch
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// typedRecord registers a type, writes two versions of record 1 under it and
+// then tightens the type so the second version no longer matches.
+func typedRecord(t *testing.T) (*DatabaseService, []entity.Record) {
+	t.Helper()
+	s := newTestService(t)
+	ctx := tenantContext("")
+	if _, err := s.RegisterRecordType(ctx, "policy", []byte(`{"type":"object","properties":{"n":{"type":"integer"},"s":{"type":"string"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	var versions []entity.Record
+	for i, data := range []map[string]interface{}{{"n": json.Number("1")}, {"n": json.Number("1"), "s": "x"}} {
+		operation := entity.OperationUpdate
+		if i == 0 {
+			operation = entity.OperationCreate
+		}
+		record, err := s.CreateRecord(ctx, entity.Record{ID: 1, Data: data, Operation: operation}, "policy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, record)
+	}
+	if _, err := s.RegisterRecordType(ctx, "policy", []byte(`{"type":"object","properties":{"n":{"type":"integer"},"s":{"type":"string","maxLength":0}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	return s, versions
+}
+
+func TestRevertRecordChecksType(t *testing.T) {
+	s, versions := typedRecord(t)
+	_, err := s.RevertRecord(tenantContext(""), versions[1])
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.SchemaVersion != 2 {
+		t.Fatalf("revert to a version the current schema refuses: %v", err)
+	}
+	if _, err := s.RevertRecord(tenantContext(""), versions[0]); err != nil {
+		t.Fatalf("revert to a version the current schema accepts: %v", err)
+	}
+}
+
+func TestCorrectRecordChecksType(t *testing.T) {
+	s, versions := typedRecord(t)
+	ctx := tenantContext("")
+	between := versions[0].EffectiveAt.Add(versions[1].EffectiveAt.Sub(versions[0].EffectiveAt) / 2)
+
+	_, err := s.CorrectRecord(ctx, 1, between, entity.Patch{"n": "one"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("correction that does not match: %v", err)
+	}
+
+	// the correction matches, but replaying the second version does not
+	_, err = s.CorrectRecord(ctx, 1, between, entity.Patch{"n": json.Number("2")})
+	if !errors.As(err, &invalid) || !strings.Contains(err.Error(), "replaying version 2") {
+		t.Fatalf("correction whose replay does not match: %v", err)
+	}
+
+	records, err := s.GetAllRecordsByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("refused corrections stored versions: %d versions", len(records))
+	}
+}
+
+func TestImportRecordsChecksTypeAtWriteTime(t *testing.T) {
+	s, _ := typedRecord(t)
+	ctx := tenantContext("")
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	_, _, err := s.ImportRecords(ctx, strings.NewReader(`{"id":1,"version":3,"data":{"n":1,"s":"y"},"created_at":"`+now+`"}`))
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.SchemaVersion != 2 {
+		t.Fatalf("import of a version that does not match the schema of its time: %v", err)
+	}
+
+	// versions written before the type had a schema are not judged by it
+	imported, _, err := s.ImportRecords(ctx, strings.NewReader(`{"id":1,"version":3,"data":{"n":"one"},"created_at":"2001-01-01T00:00:00Z"}`))
+	if err != nil || imported != 1 {
+		t.Fatalf("import of a version older than the type: %d imported, %v", imported, err)
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// The access log is append-only, like the audit trail.
+const createAccessLogTableSQL = `CREATE TABLE IF NOT EXISTS access_log (
+		"seq" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"at" TIMESTAMP NOT NULL,
+		"actor" TEXT NOT NULL,
+		"record_id" integer,
+		"version" integer,
+		"as_of" TIMESTAMP,
+		"known_at" TIMESTAMP,
+		"request" TEXT NOT NULL,
+		"client_ip" TEXT,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS access_log_record ON access_log (record_id, at);
+	CREATE TRIGGER IF NOT EXISTS access_log_no_update BEFORE UPDATE ON access_log
+	BEGIN SELECT RAISE(ABORT, 'access_log is append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS access_log_no_delete BEFORE DELETE ON access_log
+	BEGIN SELECT RAISE(ABORT, 'access_log is append-only'); END;`
+
+// AccessFilter narrows a query of the access log. Zero values leave that
+// bound open.
+type AccessFilter struct {
+	Actor    string
+	RecordID int64
+	Since    time.Time
+	Until    time.Time
+}
+
+// where renders the filter as a SQL condition and its arguments.
+func (f AccessFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if f.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.RecordID > 0 {
+		conditions = append(conditions, "record_id = ?")
+		args = append(args, f.RecordID)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "at >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "at <= ?")
+		args = append(args, f.Until.UTC())
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// AppendAccess adds an entry to the access log of the tenant.
+func (s *Storage) AppendAccess(entry entity.AccessEntry) error {
+	at := entry.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	var asOf, knownAt time.Time
+	if entry.AsOf != nil {
+		asOf = *entry.AsOf
+	}
+	if entry.KnownAt != nil {
+		knownAt = *entry.KnownAt
+	}
+	_, err := s.db.Exec(`INSERT INTO access_log (tenant, at, actor, record_id, version, as_of, known_at, request, client_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.tenant, at.UTC(), entry.Actor, nullInt64(entry.RecordID), nullInt(entry.Version),
+		nullTime(asOf), nullTime(knownAt), entry.Request, nullString(entry.ClientIP))
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// GetAccessEntries returns the access log entries of the tenant matching
+// filter, oldest first.
+func (s *Storage) GetAccessEntries(filter AccessFilter) ([]entity.AccessEntry, error) {
+	log.Println("Getting access entries...")
+	where, args := filter.where()
+	rows, err := s.db.Query(`SELECT seq, at, actor, COALESCE(record_id, 0), COALESCE(version, 0), as_of, known_at,
+		request, COALESCE(client_ip, '') FROM access_log WHERE tenant = ? AND `+where+` ORDER BY seq`,
+		append([]interface{}{s.tenant}, args...)...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []entity.AccessEntry{}
+	for rows.Next() {
+		var entry entity.AccessEntry
+		var asOf, knownAt sql.NullTime
+		err := rows.Scan(&entry.Seq, &entry.At, &entry.Actor, &entry.RecordID, &entry.Version, &asOf, &knownAt,
+			&entry.Request, &entry.ClientIP)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		entry.At = entry.At.UTC()
+		if asOf.Valid {
+			t := asOf.Time.UTC()
+			entry.AsOf = &t
+		}
+		if knownAt.Valid {
+			t := knownAt.Time.UTC()
+			entry.KnownAt = &t
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
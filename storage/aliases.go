@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrAliasTaken is returned when an alias would name a second record at some
+// time within its namespace.
+var ErrAliasTaken = errors.New("alias already names a record")
+
+// ErrAliasNotActive is returned when removing an alias the record does not
+// currently have.
+var ErrAliasNotActive = errors.New("alias does not currently name the record")
+
+// ErrAliasNotFound is returned when an alias names no record at the time asked.
+var ErrAliasNotFound = errors.New("alias does not name a record")
+
+// the partial index backs up the overlap check of AddAlias: a key is active
+// for at most one record of a namespace
+const createRecordAliasesTableSQL = `CREATE TABLE IF NOT EXISTS record_aliases (
+		"alias_id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"namespace" TEXT NOT NULL,
+		"alias_key" TEXT NOT NULL,
+		"record_id" integer NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP,
+		"assigned_by" TEXT NOT NULL,
+		"removed_by" TEXT
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS record_aliases_active ON record_aliases (tenant, namespace, alias_key)
+		WHERE valid_to IS NULL;
+	CREATE INDEX IF NOT EXISTS record_aliases_record ON record_aliases (tenant, record_id);`
+
+const aliasColumns = `alias_id, namespace, alias_key, record_id, valid_from, valid_to, assigned_by, removed_by`
+
+func scanAlias(row scanner) (*entity.Alias, error) {
+	alias := &entity.Alias{}
+	var validTo sql.NullTime
+	var removedBy sql.NullString
+	err := row.Scan(&alias.ID, &alias.Namespace, &alias.Key, &alias.RecordID, &alias.ValidFrom, &validTo, &alias.AssignedBy, &removedBy)
+	if err != nil {
+		return nil, err
+	}
+	alias.ValidFrom = alias.ValidFrom.UTC()
+	if validTo.Valid {
+		to := validTo.Time.UTC()
+		alias.ValidTo = &to
+	}
+	alias.RemovedBy = removedBy.String
+	return alias, nil
+}
+
+// AddAlias gives a record an alias from alias.ValidFrom on and audits it. It
+// fails with ErrAliasTaken if the key names any record, the same one
+// included, at or after that time.
+func (s *Storage) AddAlias(alias entity.Alias) (*entity.Alias, error) {
+	log.Println("Adding alias...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	validFrom := alias.ValidFrom.UTC()
+	var taken bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM record_aliases
+		WHERE tenant = ? AND namespace = ? AND alias_key = ? AND (valid_to IS NULL OR valid_to > ?))`,
+		s.tenant, alias.Namespace, alias.Key, validFrom).Scan(&taken)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if taken {
+		return nil, ErrAliasTaken
+	}
+
+	added, err := scanAlias(tx.QueryRow(`INSERT INTO record_aliases (tenant, namespace, alias_key, record_id, valid_from, assigned_by)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING `+aliasColumns,
+		s.tenant, alias.Namespace, alias.Key, alias.RecordID, validFrom, alias.AssignedBy))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		Actor:    alias.AssignedBy,
+		Action:   "alias.add",
+		RecordID: alias.RecordID,
+		Detail:   added.String(),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return added, tx.Commit()
+}
+
+// RemoveAlias ends an active alias of a record now and audits it.
+func (s *Storage) RemoveAlias(recordID int64, namespace, key, removedBy string) (*entity.Alias, error) {
+	log.Println("Removing alias...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	alias, err := scanAlias(tx.QueryRow(`UPDATE record_aliases SET valid_to = ?, removed_by = ?
+		WHERE tenant = ? AND record_id = ? AND namespace = ? AND alias_key = ? AND valid_to IS NULL
+		RETURNING `+aliasColumns, now, removedBy, s.tenant, recordID, namespace, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAliasNotActive
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:       now,
+		Actor:    removedBy,
+		Action:   "alias.remove",
+		RecordID: recordID,
+		Detail:   alias.String(),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return alias, tx.Commit()
+}
+
+// GetAliases returns every alias a record has had, oldest first.
+func (s *Storage) GetAliases(recordID int64) ([]entity.Alias, error) {
+	log.Println("Getting aliases...")
+	rows, err := s.db.Query(`SELECT `+aliasColumns+` FROM record_aliases
+		WHERE tenant = ? AND record_id = ? ORDER BY valid_from, alias_id`, s.tenant, recordID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []entity.Alias{}
+	for rows.Next() {
+		alias, err := scanAlias(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		aliases = append(aliases, *alias)
+	}
+	return aliases, rows.Err()
+}
+
+// ResolveAlias returns the id of the record the alias named at the given
+// time, or ErrAliasNotFound.
+func (s *Storage) ResolveAlias(namespace, key string, at time.Time) (int64, error) {
+	log.Println("Resolving alias...")
+	var id int64
+	err := s.db.QueryRow(`SELECT record_id FROM record_aliases
+		WHERE tenant = ? AND namespace = ? AND alias_key = ? AND valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)`,
+		s.tenant, namespace, key, at.UTC(), at.UTC()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrAliasNotFound
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return id, err
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrKeyNotActive is returned when revoking a key that does not exist or was
+// already revoked.
+var ErrKeyNotActive = errors.New("api key does not exist or was already revoked")
+
+const createAPIKeysTableSQL = `CREATE TABLE IF NOT EXISTS api_keys (
+		"key_id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"name" TEXT NOT NULL,
+		"prefix" TEXT NOT NULL,
+		"key_hash" TEXT NOT NULL UNIQUE,
+		"scopes" TEXT NOT NULL,
+		"created_at" TIMESTAMP NOT NULL,
+		"revoked_at" TIMESTAMP,
+		"roles" TEXT,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);`
+
+const apiKeyColumns = `key_id, name, prefix, scopes, created_at, revoked_at, roles, tenant`
+
+func scanAPIKey(row scanner) (*entity.APIKey, error) {
+	key := &entity.APIKey{}
+	var scopes string
+	var roles sql.NullString
+	var revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &revokedAt, &roles, &key.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.Roles = strings.Fields(roles.String)
+	key.CreatedAt = key.CreatedAt.UTC()
+	if revokedAt.Valid {
+		revoked := revokedAt.Time.UTC()
+		key.RevokedAt = &revoked
+	}
+	return key, nil
+}
+
+// CreateAPIKey stores a new key of the tenant by its hash and audits it as
+// issued by actor. The key only authenticates callers as the tenant.
+func (s *Storage) CreateAPIKey(name, prefix, hash string, scopes, roles []string, actor string) (*entity.APIKey, error) {
+	log.Println("Creating api key...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	key, err := scanAPIKey(tx.QueryRow(`INSERT INTO api_keys (tenant, name, prefix, key_hash, scopes, roles, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING `+apiKeyColumns,
+		s.tenant, name, prefix, hash, strings.Join(scopes, " "), nullString(strings.Join(roles, " ")), now))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:     now,
+		Actor:  actor,
+		Action: "api_key.issue",
+		Detail: fmt.Sprintf("key %d (%s), scopes %s", key.ID, key.Name, strings.Join(scopes, " ")),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return key, tx.Commit()
+}
+
+// GetActiveAPIKey returns the unrevoked key with the given hash, whatever
+// its tenant, or sql.ErrNoRows.
+func (s *Storage) GetActiveAPIKey(hash string) (*entity.APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE key_hash = ? AND revoked_at IS NULL`, hash))
+}
+
+// GetAPIKeys returns every key ever issued to the tenant, oldest first.
+func (s *Storage) GetAPIKeys() ([]entity.APIKey, error) {
+	log.Println("Getting api keys...")
+	rows, err := s.db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE tenant = ? ORDER BY key_id`, s.tenant)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []entity.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an active key of the tenant and audits it as done by
+// actor.
+func (s *Storage) RevokeAPIKey(keyID int, actor string) (*entity.APIKey, error) {
+	log.Println("Revoking api key...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	key, err := scanAPIKey(tx.QueryRow(`UPDATE api_keys SET revoked_at = ?
+		WHERE key_id = ? AND tenant = ? AND revoked_at IS NULL RETURNING `+apiKeyColumns, now, keyID, s.tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrKeyNotActive
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:     now,
+		Actor:  actor,
+		Action: "api_key.revoke",
+		Detail: fmt.Sprintf("key %d (%s)", key.ID, key.Name),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return key, tx.Commit()
+}
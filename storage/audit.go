@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// The audit trail is append-only: triggers reject any change to past entries.
+const createAuditLogTableSQL = `CREATE TABLE IF NOT EXISTS audit_log (
+		"seq" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"at" TIMESTAMP NOT NULL,
+		"actor" TEXT NOT NULL,
+		"action" TEXT NOT NULL,
+		"record_id" integer,
+		"detail" TEXT,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END;`
+
+// appendAuditIn adds an entry to the audit trail of tenant.
+func appendAuditIn(q querier, tenant string, entry entity.AuditEntry) error {
+	at := entry.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	_, err := q.Exec(`INSERT INTO audit_log (tenant, at, actor, action, record_id, detail) VALUES (?, ?, ?, ?, ?, ?)`,
+		tenant, at.UTC(), entry.Actor, entry.Action, nullInt64(entry.RecordID), nullString(entry.Detail))
+	return err
+}
+
+// AppendAudit adds an entry to the audit trail of the tenant.
+func (s *Storage) AppendAudit(entry entity.AuditEntry) error {
+	err := appendAuditIn(s.db, s.tenant, entry)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// GetAuditEntries returns the audit trail of a record, oldest first. An id
+// of 0 returns the whole trail of the tenant.
+func (s *Storage) GetAuditEntries(recordID int64) ([]entity.AuditEntry, error) {
+	log.Println("Getting audit entries...")
+	rows, err := s.db.Query(`SELECT seq, at, actor, action, COALESCE(record_id, 0), COALESCE(detail, '')
+		FROM audit_log WHERE tenant = ? AND (? = 0 OR record_id = ?) ORDER BY seq`, s.tenant, recordID, recordID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []entity.AuditEntry{}
+	for rows.Next() {
+		var entry entity.AuditEntry
+		err := rows.Scan(&entry.Seq, &entry.At, &entry.Actor, &entry.Action, &entry.RecordID, &entry.Detail)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		entry.At = entry.At.UTC()
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
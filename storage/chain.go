@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// Every version stores a hash over its contents and the hash of the version
+// of the same record stored before it (prev_hash), so editing, removing or
+// reordering rows outside the API breaks the chain. Compaction removes
+// versions on purpose; compaction_log keeps their hashes so the chain can
+// still be followed across the gap.
+
+// chainHeadIn returns the hash of the last version stored for record id of
+// tenant before seq, or of the last version overall when seq is 0. It is
+// empty for a record without versions.
+func chainHeadIn(q querier, tenant string, id int64, seq int64) (string, error) {
+	query := `SELECT hash FROM records WHERE tenant = ? AND id = ? ORDER BY seq DESC LIMIT 1`
+	args := []interface{}{tenant, id}
+	if seq > 0 {
+		query = `SELECT hash FROM records WHERE tenant = ? AND id = ? AND seq < ? ORDER BY seq DESC LIMIT 1`
+		args = append(args, seq)
+	}
+	var hash sql.NullString
+	err := q.QueryRow(query, args...).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return hash.String, err
+}
+
+// sealVersionsIn computes the hash of the versions with the given sequence
+// numbers, which were stored without one, in sequence order so each links to
+// the one before it.
+func sealVersionsIn(q querier, seqs []int64) error {
+	for _, seq := range seqs {
+		record, err := queryRecordIn(q, `SELECT `+recordColumns+` FROM records WHERE seq = ?`, seq)
+		if err != nil {
+			return err
+		}
+		prevHash, err := chainHeadIn(q, record.tenant, record.ID, record.Seq)
+		if err != nil {
+			return err
+		}
+		record.PrevHash = prevHash
+		record.Hash = record.ComputeHash(record.tenant, prevHash)
+		_, err = q.Exec(`UPDATE records SET hash = ?, prev_hash = ? WHERE seq = ?`,
+			record.Hash, nullString(record.PrevHash), record.Seq)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyChain recomputes the hash of every version of the record, or of all
+// records of the tenant when id is 0, and checks that each version links to the one stored
+// before it. It stops at the first broken link.
+func (s *Storage) VerifyChain(id int64) (*entity.ChainReport, error) {
+	log.Println("Verifying hash chain...")
+	cursor, err := s.ExportRecords(ExportFilter{MinID: id, MaxID: id})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	report := &entity.ChainReport{}
+	heads := map[int64]string{}
+	compacted := map[int64]map[string]string{}
+	for cursor.Next() {
+		record := cursor.Record()
+		report.Checked++
+
+		if record.PrevHash != heads[record.ID] {
+			if _, ok := compacted[record.ID]; !ok {
+				compacted[record.ID], err = compactedHashesIn(s.db, s.tenant, record.ID)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if reason := chainBreak(s.tenant, record, heads[record.ID], compacted[record.ID]); reason != "" {
+			report.Broken = &entity.ChainBreak{Seq: record.Seq, ID: record.ID, Version: record.Version, Reason: reason}
+			break
+		}
+		heads[record.ID] = record.Hash
+	}
+	if err := cursor.Err(); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	report.OK = report.Broken == nil
+	return report, nil
+}
+
+// chainBreak returns why record of tenant does not follow head, the hash of
+// the version of its record stored before it, or "" if it does. compacted
+// maps the hashes of the record's compacted versions to the hashes they
+// linked to.
+func chainBreak(tenant string, record *entity.Record, head string, compacted map[string]string) string {
+	switch {
+	case record.Hash == "":
+		return "version has no hash"
+	case record.ComputeHash(tenant, record.PrevHash) != record.Hash:
+		return "hash does not match the version's contents"
+	case record.PrevHash != head && !bridges(compacted, record.PrevHash, head):
+		return "previous hash does not match the version stored before it"
+	}
+	return ""
+}
+
+// compactedHashesIn maps the hash of every compacted version of record id of
+// tenant to the hash it linked to.
+func compactedHashesIn(q querier, tenant string, id int64) (map[string]string, error) {
+	rows, err := q.Query(`SELECT hash, prev_hash FROM compaction_log
+		WHERE tenant = ? AND id = ? AND hash IS NOT NULL`, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := map[string]string{}
+	for rows.Next() {
+		var hash string
+		var prevHash sql.NullString
+		if err := rows.Scan(&hash, &prevHash); err != nil {
+			return nil, err
+		}
+		links[hash] = prevHash.String
+	}
+	return links, rows.Err()
+}
+
+// bridges reports whether prevHash reaches head through compacted versions.
+func bridges(compacted map[string]string, prevHash, head string) bool {
+	for range compacted {
+		next, ok := compacted[prevHash]
+		if !ok {
+			return false
+		}
+		if next == head {
+			return true
+		}
+		prevHash = next
+	}
+	return false
+}
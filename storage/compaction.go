@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+const createCompactionLogTableSQL = `CREATE TABLE IF NOT EXISTS compaction_log (
+		"seq" integer NOT NULL,
+		"id" integer NOT NULL,
+		"version" integer NOT NULL,
+		"created_at" TIMESTAMP,
+		"policy" TEXT,
+		"compacted_at" TIMESTAMP NOT NULL,
+		"hash" TEXT,
+		"prev_hash" TEXT,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);`
+
+// retentionPeriods maps a RetentionRule period to the SQLite strftime format
+// that buckets versions into it.
+var retentionPeriods = map[string]string{
+	"hour":  "%Y-%m-%d %H",
+	"day":   "%Y-%m-%d",
+	"week":  "%Y-%W",
+	"month": "%Y-%m",
+}
+
+// RetentionRule selects history to collapse: among the versions recorded
+// before Before, only the last one recorded in each Period is kept. MinID,
+// MaxID and Tag narrow the records it applies to when set.
+type RetentionRule struct {
+	Name   string
+	MinID  int64
+	MaxID  int64
+	Tag    string
+	Before time.Time
+	// Period is one of "hour", "day", "week" or "month".
+	Period string
+}
+
+// CompactRecords removes the versions of the tenant's records selected by
+// rule and returns them.
+//
+// A version is never removed if its record is under an active legal hold, if
+// it is the last one of its period, or if another version refers to it
+// through source_version, such as the target of a revert or a version
+// replayed by a correction. Versions stored as patches
+// on top of a removed version are rewritten as full snapshots first, so every
+// remaining version can still be read. Each removal is written to
+// compaction_log along with its hash, so the hash chain of the record can
+// still be verified across the gap.
+func (s *Storage) CompactRecords(rule RetentionRule) ([]*entity.Record, error) {
+	format, ok := retentionPeriods[rule.Period]
+	if !ok {
+		return nil, fmt.Errorf("unknown retention period %q", rule.Period)
+	}
+
+	conditions := []string{"r.tenant = ?", "r.created_at < ?", "r.id NOT IN " + activeHold}
+	args := []interface{}{s.tenant, rule.Before.UTC(), s.tenant}
+	if rule.MinID > 0 {
+		conditions = append(conditions, "r.id >= ?")
+		args = append(args, rule.MinID)
+	}
+	if rule.MaxID > 0 {
+		conditions = append(conditions, "r.id <= ?")
+		args = append(args, rule.MaxID)
+	}
+	if rule.Tag != "" {
+		conditions = append(conditions, "r.id IN (SELECT id FROM record_tags WHERE tenant = r.tenant AND tag = ?)")
+		args = append(args, rule.Tag)
+	}
+	args = append(args, format)
+
+	candidatesSQL := `SELECT ` + prefixColumns("r", recordColumns) + ` FROM records r
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		AND r.seq NOT IN (SELECT MAX(seq) FROM records WHERE tenant = r.tenant AND id = r.id GROUP BY strftime(?, created_at))
+		AND NOT EXISTS (SELECT 1 FROM records ref WHERE ref.tenant = r.tenant AND ref.id = r.id AND ref.source_version = r.version)
+		ORDER BY r.seq`
+
+	log.Println("Compacting records...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	removed, err := queryRecordsIn(tx, candidatesSQL, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, record := range removed {
+		err = s.detachChildrenIn(tx, record)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		_, err = tx.Exec(`DELETE FROM records WHERE seq = ?`, record.Seq)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO compaction_log (tenant, seq, id, version, created_at, policy, compacted_at, hash, prev_hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.tenant, record.Seq, record.ID, record.Version, record.CreatedAt, nullString(rule.Name), now,
+			nullString(record.Hash), nullString(record.PrevHash))
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	return records(removed), tx.Commit()
+}
+
+// detachChildrenIn rewrites the versions stored as patches on top of record so
+// they no longer depend on it: each becomes a full snapshot whose parent is
+// record's parent, with its patch recomputed against that parent.
+func (s *Storage) detachChildrenIn(q querier, record *storedRecord) error {
+	children, err := queryRecordsIn(q, `SELECT `+recordColumns+` FROM records WHERE parent_seq = ?`, record.Seq)
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		return nil
+	}
+
+	// an earlier removal in the same pass may have re-parented record
+	var parentSeq sql.NullInt64
+	err = q.QueryRow(`SELECT parent_seq FROM records WHERE seq = ?`, record.Seq).Scan(&parentSeq)
+	if err != nil {
+		return err
+	}
+
+	var grandparentData map[string]interface{}
+	var grandparentSeq interface{}
+	if parentSeq.Valid {
+		grandparent, err := queryRecordIn(q, `SELECT `+recordColumns+` FROM records WHERE seq = ?`, parentSeq.Int64)
+		if err != nil {
+			return err
+		}
+		grandparentData, grandparentSeq = grandparent.Data, grandparent.Seq
+	}
+
+	for _, child := range children {
+		data, err := json.Marshal(child.Data)
+		if err != nil {
+			return err
+		}
+		patch, err := json.Marshal(entity.Diff(grandparentData, child.Data))
+		if err != nil {
+			return err
+		}
+		_, err = q.Exec(`UPDATE records SET data = ?, patch = ?, parent_seq = ?, delta_depth = 0 WHERE seq = ?`,
+			string(data), string(patch), grandparentSeq, child.Seq)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefixColumns qualifies a comma separated column list with a table alias.
+func prefixColumns(alias, columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		fields[i] = alias + "." + strings.TrimSpace(field)
+	}
+	return strings.Join(fields, ", ")
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// CorrectRecord inserts a retroactive change that took effect at effectiveAt
+// and replays every later version of the current timeline on top of it.
+//
+// The corrected version is the state in effect at effectiveAt with patch
+// applied. Each later version's stored patch is then re-applied in effective
+// order, producing new rows with the original effective times. The versions
+// they replace are marked superseded rather than changed, so the record can
+// still be read as it was known before the correction.
+//
+// The new versions are attributed to author, and the data of each is passed
+// to check before it is stored; an error from check aborts the correction.
+// It returns them, the correction first.
+func (s *Storage) CorrectRecord(id int64, effectiveAt time.Time, patch entity.Patch, author string, check func(data map[string]interface{}) error) ([]*entity.Record, error) {
+	log.Println("Correcting record...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	timeline, err := queryRecordsIn(tx, `SELECT `+recordColumns+` FROM records
+		WHERE tenant = ? AND id = ? AND `+currentTimeline+` ORDER BY `+effectiveOrder, s.tenant, id)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if len(timeline) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	// split the timeline into what was in effect at effectiveAt and what
+	// came after it
+	var base *storedRecord
+	var later []*storedRecord
+	for _, record := range timeline {
+		if record.EffectiveAt.After(effectiveAt) {
+			later = append(later, record)
+		} else {
+			base = record
+		}
+	}
+
+	var baseData map[string]interface{}
+	if base != nil {
+		baseData = base.Data
+	}
+	corrected, err := patch.Apply(baseData)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(corrected); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	correction, err := s.insertVersionIn(tx, id, corrected, base, now, VersionMeta{
+		Operation:   entity.OperationCorrection,
+		EffectiveAt: effectiveAt,
+		Author:      author,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	inserted := []*entity.Record{correction.Record}
+
+	previousOriginal, previous := baseData, correction
+	for _, record := range later {
+		// versions stored before patches were kept are diffed on the fly
+		replayed := record.Patch
+		if replayed == nil {
+			replayed = entity.Diff(previousOriginal, record.Data)
+		}
+
+		_, err = tx.Exec(`UPDATE records SET superseded_at = ? WHERE seq = ?`, now.UTC(), record.Seq)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		// a later change may no longer fit, such as one inside an object the
+		// correction removed
+		replayedData, err := replayed.Apply(previous.Data)
+		if err != nil {
+			return nil, fmt.Errorf("replaying version %d: %w", record.Version, err)
+		}
+		if err := check(replayedData); err != nil {
+			return nil, fmt.Errorf("replaying version %d: %w", record.Version, err)
+		}
+		replay, err := s.insertVersionIn(tx, id, replayedData, previous, now, VersionMeta{
+			Operation:     entity.OperationReplay,
+			SourceVersion: record.Version,
+			EffectiveAt:   record.EffectiveAt,
+			Author:        author,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		inserted = append(inserted, replay.Record)
+		previousOriginal, previous = record.Data, replay
+	}
+
+	return inserted, tx.Commit()
+}
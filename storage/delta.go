@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// DefaultSnapshotInterval is how many versions may be chained as patches
+// before a full snapshot of the data is stored again.
+const DefaultSnapshotInterval = 16
+
+// ErrBrokenDeltaChain is returned when a version stored as a patch cannot be
+// traced back to a snapshot.
+var ErrBrokenDeltaChain = errors.New("record version cannot be reconstructed")
+
+// Versions are stored as persistent data: most rows only hold the patch
+// against their parent version (parent_seq) and leave data NULL. Every
+// snapshot interval versions the full data is written again, so rebuilding
+// any version applies at most that many patches on top of a snapshot.
+
+// storedRecord is a row of the records table along with how its data is
+// stored. Until it is materialized, a delta row has nil Data.
+type storedRecord struct {
+	*entity.Record
+	parentSeq int64
+	depth     int // patches since the last snapshot; 0 for snapshots
+	tenant    string
+}
+
+// scanRecord reads one row selected with recordColumns.
+func scanRecord(row scanner) (*storedRecord, error) {
+	record := &storedRecord{Record: &entity.Record{}}
+	var data, operation, patch, hash, prevHash, author sql.NullString
+	var deletedAt, effectiveAt, supersededAt sql.NullTime
+	var sourceVersion, parentSeq, depth sql.NullInt64
+	err := row.Scan(&record.Seq, &record.ID, &record.Version, &data, &record.CreatedAt, &deletedAt,
+		&operation, &sourceVersion, &effectiveAt, &supersededAt, &patch, &parentSeq, &depth,
+		&hash, &prevHash, &author, &record.tenant)
+	if err != nil {
+		return nil, err
+	}
+	if data.Valid {
+		record.Data, err = entity.DecodeData([]byte(data.String))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if patch.Valid {
+		err = json.Unmarshal([]byte(patch.String), &record.Patch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	record.CreatedAt = record.CreatedAt.UTC()
+	if deletedAt.Valid {
+		record.DeletedAt = deletedAt.Time.UTC()
+	}
+	record.EffectiveAt = record.CreatedAt
+	if effectiveAt.Valid {
+		record.EffectiveAt = effectiveAt.Time.UTC()
+	}
+	if supersededAt.Valid {
+		superseded := supersededAt.Time.UTC()
+		record.SupersededAt = &superseded
+	}
+	record.Operation = operation.String
+	record.Hash = hash.String
+	record.PrevHash = prevHash.String
+	record.Author = author.String
+	record.SourceVersion = int(sourceVersion.Int64)
+	record.parentSeq = parentSeq.Int64
+	record.depth = int(depth.Int64)
+	return record, nil
+}
+
+// materializeIn fills in the data of every delta row in records. cache maps
+// sequence numbers to already materialized data and is updated as versions
+// are rebuilt; it may be nil.
+func materializeIn(q querier, records []*storedRecord, cache map[int64]map[string]interface{}) error {
+	if cache == nil {
+		cache = map[int64]map[string]interface{}{}
+	}
+	for _, record := range records {
+		if record.Data != nil {
+			cache[record.Seq] = record.Data
+		}
+	}
+	for _, record := range records {
+		if record.Data != nil {
+			continue
+		}
+		data, err := reconstructIn(q, record, cache)
+		if err != nil {
+			return err
+		}
+		record.Data = data
+	}
+	return nil
+}
+
+// reconstructIn rebuilds a delta row's data by walking parents back to a
+// snapshot, or to a version already in cache, and re-applying the patches.
+func reconstructIn(q querier, record *storedRecord, cache map[int64]map[string]interface{}) (map[string]interface{}, error) {
+	var chain []*storedRecord
+	var base map[string]interface{}
+	for current := record; ; {
+		chain = append(chain, current)
+		if current.parentSeq == 0 {
+			return nil, fmt.Errorf("%w: version %d of record %d", ErrBrokenDeltaChain, record.Version, record.ID)
+		}
+		if data, ok := cache[current.parentSeq]; ok {
+			base = data
+			break
+		}
+		parent, err := scanRecord(q.QueryRow(`SELECT `+recordColumns+` FROM records WHERE seq = ?`, current.parentSeq))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: version %d of record %d", ErrBrokenDeltaChain, record.Version, record.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if parent.Data != nil {
+			cache[parent.Seq] = parent.Data
+			base = parent.Data
+			break
+		}
+		current = parent
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		base, err = chain[i].Patch.Apply(base)
+		if err != nil {
+			return nil, fmt.Errorf("%w: version %d of record %d: %v", ErrBrokenDeltaChain, chain[i].Version, record.ID, err)
+		}
+		cache[chain[i].Seq] = base
+	}
+	return base, nil
+}
+
+// records strips the storage details from materialized rows.
+func records(stored []*storedRecord) []*entity.Record {
+	var result []*entity.Record
+	for _, record := range stored {
+		result = append(result, record.Record)
+	}
+	return result
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrImportConflict is returned when an imported version already exists with
+// different data.
+var ErrImportConflict = errors.New("version already exists with different data")
+
+// ExportFilter narrows an export. Zero values leave that bound open.
+type ExportFilter struct {
+	MinID  int64
+	MaxID  int64
+	Since  time.Time
+	Until  time.Time
+	MinSeq int64
+	MaxSeq int64
+}
+
+// where renders the filter as a SQL condition and its arguments.
+func (f ExportFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if f.MinID > 0 {
+		conditions = append(conditions, "id >= ?")
+		args = append(args, f.MinID)
+	}
+	if f.MaxID > 0 {
+		conditions = append(conditions, "id <= ?")
+		args = append(args, f.MaxID)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, f.Until.UTC())
+	}
+	if f.MinSeq > 0 {
+		conditions = append(conditions, "seq >= ?")
+		args = append(args, f.MinSeq)
+	}
+	if f.MaxSeq > 0 {
+		conditions = append(conditions, "seq <= ?")
+		args = append(args, f.MaxSeq)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// RecordCursor iterates over record versions without loading them all into
+// memory. Callers must Close it.
+type RecordCursor struct {
+	db     *sql.DB
+	rows   *sql.Rows
+	record *storedRecord
+	cache  map[int64]map[string]interface{}
+	err    error
+}
+
+// cursorCacheSize bounds how many materialized versions a cursor remembers
+// to rebuild the delta versions that follow them.
+const cursorCacheSize = 4096
+
+// Next advances to the next version, returning false when the cursor is
+// exhausted or an error occurred.
+func (c *RecordCursor) Next() bool {
+	if c.err != nil || !c.rows.Next() {
+		return false
+	}
+	c.record, c.err = scanRecord(c.rows)
+	if c.err != nil {
+		return false
+	}
+	if len(c.cache) >= cursorCacheSize {
+		c.cache = map[int64]map[string]interface{}{}
+	}
+	c.err = materializeIn(c.db, []*storedRecord{c.record}, c.cache)
+	return c.err == nil
+}
+
+// Record returns the version the cursor currently points at.
+func (c *RecordCursor) Record() *entity.Record {
+	return c.record.Record
+}
+
+// Err returns the first error encountered while iterating.
+func (c *RecordCursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.rows.Err()
+}
+
+func (c *RecordCursor) Close() error {
+	return c.rows.Close()
+}
+
+// ExportRecords returns a cursor over every version of the tenant's records
+// matching filter, in sequence order.
+func (s *Storage) ExportRecords(filter ExportFilter) (*RecordCursor, error) {
+	log.Println("Exporting records...")
+	where, args := filter.where()
+	rows, err := s.db.Query(`SELECT `+recordColumns+` FROM records WHERE tenant = ? AND `+where+` ORDER BY seq`,
+		append([]interface{}{s.tenant}, args...)...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return &RecordCursor{db: s.db, rows: rows, cache: map[int64]map[string]interface{}{}}, nil
+}
+
+// ImportRecords inserts the versions produced by next into the tenant until
+// it returns a nil record, all in one transaction. Versions that already
+// exist with the same data are skipped so re-running an import is harmless;
+// with different data nothing is imported and ErrImportConflict is returned.
+// A zero version is assigned the next free number and a zero created_at
+// becomes the current time.
+func (s *Storage) ImportRecords(next func() (*entity.Record, error)) (imported int, skipped int, err error) {
+	log.Println("Importing records...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	statement, err := tx.Prepare(`INSERT OR IGNORE INTO records
+		(tenant, id, version, data, created_at, deleted_at, operation, source_version, effective_at, superseded_at, patch, author)
+		VALUES (?, ?, COALESCE(?, (SELECT COALESCE(MAX(version), 0) + 1 FROM records WHERE tenant = ? AND id = ?)), ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer statement.Close()
+
+	var inserted []int64
+	for {
+		record, err := next()
+		if err != nil {
+			return 0, 0, err
+		}
+		if record == nil {
+			break
+		}
+
+		data, err := json.Marshal(record.Data)
+		if err != nil {
+			return 0, 0, err
+		}
+		var patch interface{}
+		if record.Patch != nil {
+			patchBytes, err := json.Marshal(record.Patch)
+			if err != nil {
+				return 0, 0, err
+			}
+			patch = string(patchBytes)
+		}
+		var version interface{}
+		if record.Version > 0 {
+			version = record.Version
+		}
+		createdAt := record.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		effectiveAt := record.EffectiveAt
+		if effectiveAt.IsZero() {
+			effectiveAt = createdAt
+		}
+		var supersededAt time.Time
+		if record.SupersededAt != nil {
+			supersededAt = *record.SupersededAt
+		}
+
+		result, err := statement.Exec(s.tenant, record.ID, version, s.tenant, record.ID, string(data), createdAt.UTC(), nullTime(record.DeletedAt),
+			nullString(record.Operation), nullInt(record.SourceVersion), effectiveAt.UTC(), nullTime(supersededAt), patch,
+			nullString(record.Author))
+		if err != nil {
+			return 0, 0, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		if affected == 0 {
+			existing, err := queryRecordIn(tx, `SELECT `+recordColumns+` FROM records WHERE tenant = ? AND id = ? AND version = ?`,
+				s.tenant, record.ID, version)
+			if err != nil {
+				return 0, 0, err
+			}
+			existingData, err := json.Marshal(existing.Data)
+			if err != nil {
+				return 0, 0, err
+			}
+			if !bytes.Equal(existingData, data) {
+				return 0, 0, fmt.Errorf("record %d version %d: %w", record.ID, record.Version, ErrImportConflict)
+			}
+			skipped++
+			continue
+		}
+		seq, err := result.LastInsertId()
+		if err != nil {
+			return 0, 0, err
+		}
+		inserted = append(inserted, seq)
+		imported++
+	}
+
+	// imported versions are chained after whatever was already stored
+	if err := sealVersionsIn(tx, inserted); err != nil {
+		return 0, 0, err
+	}
+
+	return imported, skipped, tx.Commit()
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrLegalHold is returned by destructive operations on a record that is
+// under an active legal hold.
+var ErrLegalHold = errors.New("record is under legal hold")
+
+// ErrHoldNotActive is returned when lifting a hold that does not exist or was
+// already lifted.
+var ErrHoldNotActive = errors.New("legal hold does not exist or was already lifted")
+
+const createLegalHoldsTableSQL = `CREATE TABLE IF NOT EXISTS legal_holds (
+		"hold_id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"record_id" integer NOT NULL,
+		"case_ref" TEXT NOT NULL,
+		"placed_by" TEXT NOT NULL,
+		"placed_at" TIMESTAMP NOT NULL,
+		"lifted_by" TEXT,
+		"lifted_at" TIMESTAMP,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);`
+
+// activeHold is a SQL condition matching the ids under an active legal hold
+// in the tenant given as its argument.
+const activeHold = `(SELECT record_id FROM legal_holds WHERE lifted_at IS NULL AND tenant = ?)`
+
+const holdColumns = `hold_id, record_id, case_ref, placed_by, placed_at, lifted_by, lifted_at`
+
+func scanHold(row scanner) (*entity.LegalHold, error) {
+	hold := &entity.LegalHold{}
+	var liftedBy sql.NullString
+	var liftedAt sql.NullTime
+	err := row.Scan(&hold.ID, &hold.RecordID, &hold.CaseRef, &hold.PlacedBy, &hold.PlacedAt, &liftedBy, &liftedAt)
+	if err != nil {
+		return nil, err
+	}
+	hold.PlacedAt = hold.PlacedAt.UTC()
+	hold.LiftedBy = liftedBy.String
+	if liftedAt.Valid {
+		lifted := liftedAt.Time.UTC()
+		hold.LiftedAt = &lifted
+	}
+	return hold, nil
+}
+
+// PlaceLegalHold puts a record under legal hold and audits it.
+func (s *Storage) PlaceLegalHold(recordID int64, caseRef, placedBy string) (*entity.LegalHold, error) {
+	log.Println("Placing legal hold...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hold, err := scanHold(tx.QueryRow(`INSERT INTO legal_holds (tenant, record_id, case_ref, placed_by, placed_at)
+		VALUES (?, ?, ?, ?, ?) RETURNING `+holdColumns, s.tenant, recordID, caseRef, placedBy, now))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:       now,
+		Actor:    placedBy,
+		Action:   "legal_hold.place",
+		RecordID: recordID,
+		Detail:   fmt.Sprintf("hold %d, case %s", hold.ID, caseRef),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// LiftLegalHold lifts an active hold of the tenant and audits it.
+func (s *Storage) LiftLegalHold(holdID int, liftedBy string) (*entity.LegalHold, error) {
+	log.Println("Lifting legal hold...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hold, err := scanHold(tx.QueryRow(`UPDATE legal_holds SET lifted_by = ?, lifted_at = ?
+		WHERE hold_id = ? AND tenant = ? AND lifted_at IS NULL RETURNING `+holdColumns, liftedBy, now, holdID, s.tenant))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrHoldNotActive
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:       now,
+		Actor:    liftedBy,
+		Action:   "legal_hold.lift",
+		RecordID: hold.RecordID,
+		Detail:   fmt.Sprintf("hold %d, case %s", hold.ID, hold.CaseRef),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return hold, tx.Commit()
+}
+
+// GetLegalHolds returns every hold ever placed on a record, oldest first.
+func (s *Storage) GetLegalHolds(recordID int64) ([]entity.LegalHold, error) {
+	log.Println("Getting legal holds...")
+	rows, err := s.db.Query(`SELECT `+holdColumns+` FROM legal_holds
+		WHERE tenant = ? AND record_id = ? ORDER BY hold_id`, s.tenant, recordID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []entity.LegalHold{}
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		holds = append(holds, *hold)
+	}
+	return holds, rows.Err()
+}
+
+// checkNoHoldIn returns ErrLegalHold if the record of tenant is under an
+// active hold.
+func checkNoHoldIn(q querier, tenant string, recordID int64) error {
+	var held bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM legal_holds
+		WHERE tenant = ? AND record_id = ? AND lifted_at IS NULL)`, tenant, recordID).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+	return nil
+}
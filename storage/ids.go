@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"errors"
+	"log"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrRecordIDsExhausted is returned when every id up to entity.MaxRecordID
+// has been used.
+var ErrRecordIDsExhausted = errors.New("every record id has been handed out")
+
+// record_ids holds the last id handed out to each tenant by NextRecordID.
+const createRecordIDsTableSQL = `CREATE TABLE IF NOT EXISTS record_ids (
+		"tenant" TEXT PRIMARY KEY,
+		"last_id" integer NOT NULL
+	);`
+
+// NextRecordID reserves an id above every id the tenant has used and
+// returns it. Reserved ids are never handed out again, even if nothing is
+// stored under them, and ids clients chose themselves are skipped. Ids above
+// entity.MaxRecordID, which older versions accepted, are left out, so the
+// next id cannot overflow; ErrRecordIDsExhausted is returned past it.
+func (s *Storage) NextRecordID() (int64, error) {
+	log.Println("Reserving record id...")
+	var id int64
+	err := s.db.QueryRow(`INSERT INTO record_ids (tenant, last_id)
+		VALUES (?, (SELECT COALESCE(MAX(id), 0) + 1 FROM records WHERE tenant = ? AND id <= ?))
+		ON CONFLICT (tenant) DO UPDATE
+		SET last_id = MIN(MAX(last_id, (SELECT COALESCE(MAX(id), 0) FROM records WHERE tenant = excluded.tenant AND id <= ?)) + 1, ?)
+		RETURNING last_id`, s.tenant, s.tenant, entity.MaxRecordID, entity.MaxRecordID, int64(entity.MaxRecordID)+1).Scan(&id)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	if id > entity.MaxRecordID {
+		return 0, ErrRecordIDsExhausted
+	}
+	return id, nil
+}
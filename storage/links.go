@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrLinkOverlaps is returned when a link would be in effect at the same time
+// as another link of the same type between the same records.
+var ErrLinkOverlaps = errors.New("link overlaps an existing link")
+
+// ErrLinkNotActive is returned when ending a link that does not exist or is
+// not in effect at the time it would end.
+var ErrLinkNotActive = errors.New("link does not exist or is not in effect at that time")
+
+const createRecordLinksTableSQL = `CREATE TABLE IF NOT EXISTS record_links (
+		"link_id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"type" TEXT NOT NULL,
+		"from_id" integer NOT NULL,
+		"to_id" integer NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP,
+		"created_by" TEXT NOT NULL,
+		"ended_by" TEXT
+	);
+	CREATE INDEX IF NOT EXISTS record_links_from ON record_links (tenant, from_id);
+	CREATE INDEX IF NOT EXISTS record_links_to ON record_links (tenant, to_id);`
+
+const linkColumns = `link_id, type, from_id, to_id, valid_from, valid_to, created_by, ended_by`
+
+// LinkFilter narrows the links of a record. Zero values match every link.
+type LinkFilter struct {
+	Type string
+	// Direction is "out" for links from the record, "in" for links to it,
+	// or "" for both.
+	Direction string
+	// AsOf keeps only the links in effect at that time.
+	AsOf time.Time
+}
+
+// where renders the filter for the links of recordID as a SQL condition and
+// its arguments.
+func (f LinkFilter) where(recordID int64) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	switch f.Direction {
+	case "out":
+		conditions = append(conditions, "from_id = ?")
+		args = append(args, recordID)
+	case "in":
+		conditions = append(conditions, "to_id = ?")
+		args = append(args, recordID)
+	default:
+		conditions = append(conditions, "(from_id = ? OR to_id = ?)")
+		args = append(args, recordID, recordID)
+	}
+	if f.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, f.Type)
+	}
+	if !f.AsOf.IsZero() {
+		conditions = append(conditions, "valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)")
+		args = append(args, f.AsOf.UTC(), f.AsOf.UTC())
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func scanLink(row scanner) (*entity.Link, error) {
+	link := &entity.Link{}
+	var validTo sql.NullTime
+	var endedBy sql.NullString
+	err := row.Scan(&link.ID, &link.Type, &link.FromID, &link.ToID, &link.ValidFrom, &validTo, &link.CreatedBy, &endedBy)
+	if err != nil {
+		return nil, err
+	}
+	link.ValidFrom = link.ValidFrom.UTC()
+	if validTo.Valid {
+		to := validTo.Time.UTC()
+		link.ValidTo = &to
+	}
+	link.EndedBy = endedBy.String
+	return link, nil
+}
+
+// linkDetail describes a link in the audit trail.
+func linkDetail(link *entity.Link) string {
+	return fmt.Sprintf("link %d: %d %s %d", link.ID, link.FromID, link.Type, link.ToID)
+}
+
+// AddLink stores a link and audits it. It fails with ErrLinkOverlaps if a
+// link of the same type between the same records is in effect at any time
+// the new one is.
+func (s *Storage) AddLink(link entity.Link) (*entity.Link, error) {
+	log.Println("Adding link...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	validFrom := link.ValidFrom.UTC()
+	var validTo interface{}
+	overlap := `SELECT EXISTS (SELECT 1 FROM record_links
+		WHERE tenant = ? AND type = ? AND from_id = ? AND to_id = ? AND (valid_to IS NULL OR valid_to > ?)`
+	args := []interface{}{s.tenant, link.Type, link.FromID, link.ToID, validFrom}
+	if link.ValidTo != nil {
+		validTo = link.ValidTo.UTC()
+		overlap += ` AND valid_from < ?`
+		args = append(args, validTo)
+	}
+	var overlaps bool
+	err = tx.QueryRow(overlap+`)`, args...).Scan(&overlaps)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if overlaps {
+		return nil, ErrLinkOverlaps
+	}
+
+	added, err := scanLink(tx.QueryRow(`INSERT INTO record_links (tenant, type, from_id, to_id, valid_from, valid_to, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING `+linkColumns,
+		s.tenant, link.Type, link.FromID, link.ToID, validFrom, validTo, link.CreatedBy))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		Actor:    link.CreatedBy,
+		Action:   "link.add",
+		RecordID: link.FromID,
+		Detail:   linkDetail(added),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return added, tx.Commit()
+}
+
+// EndLink ends a link of the record, from either side, at the given time
+// and audits it. The link must be in effect then.
+func (s *Storage) EndLink(recordID int64, linkID int, at time.Time, endedBy string) (*entity.Link, error) {
+	log.Println("Ending link...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	at = at.UTC()
+	link, err := scanLink(tx.QueryRow(`UPDATE record_links SET valid_to = ?, ended_by = ?
+		WHERE tenant = ? AND link_id = ? AND (from_id = ? OR to_id = ?)
+		AND valid_from < ? AND (valid_to IS NULL OR valid_to > ?)
+		RETURNING `+linkColumns, at, endedBy, s.tenant, linkID, recordID, recordID, at, at))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLinkNotActive
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		Actor:    endedBy,
+		Action:   "link.end",
+		RecordID: link.FromID,
+		Detail:   fmt.Sprintf("%s, ended at %s", linkDetail(link), at.Format(time.RFC3339Nano)),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return link, tx.Commit()
+}
+
+// GetLinks returns the links of a record matching filter, oldest first.
+func (s *Storage) GetLinks(recordID int64, filter LinkFilter) ([]entity.Link, error) {
+	log.Println("Getting links...")
+	where, args := filter.where(recordID)
+	rows, err := s.db.Query(`SELECT `+linkColumns+` FROM record_links
+		WHERE tenant = ? AND `+where+` ORDER BY valid_from, link_id`, append([]interface{}{s.tenant}, args...)...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []entity.Link{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, rows.Err()
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrRecordMerged is returned when merging a record that was already merged
+// into another, or into such a record.
+var ErrRecordMerged = errors.New("record was already merged into another")
+
+// a record is merged at most once, so from_id is the key
+const createRecordMergesTableSQL = `CREATE TABLE IF NOT EXISTS record_merges (
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"from_id" integer NOT NULL,
+		"into_id" integer NOT NULL,
+		"merged_at" TIMESTAMP NOT NULL,
+		"recorded_at" TIMESTAMP,
+		"strategy" TEXT NOT NULL,
+		"merged_by" TEXT NOT NULL,
+		"version" integer NOT NULL,
+		PRIMARY KEY (tenant, from_id)
+	);
+	CREATE INDEX IF NOT EXISTS record_merges_into ON record_merges (tenant, into_id);`
+
+const mergeColumns = `from_id, into_id, merged_at, recorded_at, strategy, merged_by, version`
+
+func scanMerge(row scanner) (*entity.Merge, error) {
+	merge := &entity.Merge{}
+	err := row.Scan(&merge.FromID, &merge.IntoID, &merge.MergedAt, &merge.RecordedAt, &merge.Strategy, &merge.MergedBy, &merge.Version)
+	if err != nil {
+		return nil, err
+	}
+	merge.MergedAt = merge.MergedAt.UTC()
+	merge.RecordedAt = merge.RecordedAt.UTC()
+	return merge, nil
+}
+
+// mergedIn reports whether the record of tenant was merged into another.
+func mergedIn(q querier, tenant string, id int64) (bool, error) {
+	var merged bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM record_merges WHERE tenant = ? AND from_id = ?)`,
+		tenant, id).Scan(&merged)
+	return merged, err
+}
+
+// MergeRecord stores data, the combined data of both records, as a new
+// version of merge.IntoID in effect from merge.MergedAt and records that
+// merge.FromID was merged into it, auditing both records. Neither record may
+// have been merged before, and fromSeq and intoSeq must still be their
+// current versions, the ones data was combined from; otherwise
+// ErrRecordChanged is returned.
+func (s *Storage) MergeRecord(merge entity.Merge, data map[string]interface{}, fromSeq, intoSeq int64, meta VersionMeta) (*entity.Merge, *entity.Record, error) {
+	log.Println("Merging records...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	for _, id := range []int64{merge.FromID, merge.IntoID} {
+		merged, err := mergedIn(tx, s.tenant, id)
+		if err != nil {
+			log.Println(err)
+			return nil, nil, err
+		}
+		if merged {
+			return nil, nil, ErrRecordMerged
+		}
+	}
+
+	if _, err := currentVersionIn(tx, s.tenant, merge.FromID, fromSeq); err != nil {
+		return nil, nil, err
+	}
+	latest, err := currentVersionIn(tx, s.tenant, merge.IntoID, intoSeq)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	meta.EffectiveAt = merge.MergedAt
+	record, err := s.insertVersionIn(tx, merge.IntoID, data, latest, now, meta)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+
+	merged, err := scanMerge(tx.QueryRow(`INSERT INTO record_merges (tenant, from_id, into_id, merged_at, recorded_at, strategy, merged_by, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING `+mergeColumns,
+		s.tenant, merge.FromID, merge.IntoID, merge.MergedAt.UTC(), now, merge.Strategy, merge.MergedBy, record.Version))
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+	detail := fmt.Sprintf("%d merged into %d from %s, %s", merged.FromID, merged.IntoID, merged.MergedAt.Format(time.RFC3339), merged.Strategy)
+	for _, id := range []int64{merged.FromID, merged.IntoID} {
+		err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+			At:       now,
+			Actor:    merged.MergedBy,
+			Action:   "record.merge",
+			RecordID: id,
+			Detail:   detail,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, nil, err
+		}
+	}
+
+	return merged, record.Record, tx.Commit()
+}
+
+// GetMerge returns the merge of the record into another, or sql.ErrNoRows if
+// it was not merged.
+func (s *Storage) GetMerge(id int64) (*entity.Merge, error) {
+	log.Println("Getting merge...")
+	return scanMerge(s.db.QueryRow(`SELECT `+mergeColumns+` FROM record_merges
+		WHERE tenant = ? AND from_id = ?`, s.tenant, id))
+}
+
+// GetMerges returns the merges of the record into another and of other
+// records into it, oldest first.
+func (s *Storage) GetMerges(id int64) ([]entity.Merge, error) {
+	log.Println("Getting merges...")
+	rows, err := s.db.Query(`SELECT `+mergeColumns+` FROM record_merges
+		WHERE tenant = ? AND (from_id = ? OR into_id = ?) ORDER BY merged_at, from_id`, s.tenant, id, id)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	merges := []entity.Merge{}
+	for rows.Next() {
+		merge, err := scanMerge(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		merges = append(merges, *merge)
+	}
+	return merges, rows.Err()
+}
+
+// ResolveMerges follows the merges of the record in effect at asOf, as
+// recorded by knownAt, and returns the id of the record that holds its data
+// then. Merged records can not be merged into, so the chain always ends.
+func (s *Storage) ResolveMerges(id int64, asOf, knownAt time.Time) (int64, error) {
+	log.Println("Resolving merges...")
+	for {
+		var into int64
+		err := s.db.QueryRow(`SELECT into_id FROM record_merges
+			WHERE tenant = ? AND from_id = ? AND merged_at <= ? AND recorded_at <= ?`,
+			s.tenant, id, asOf.UTC(), knownAt.UTC()).Scan(&into)
+		if errors.Is(err, sql.ErrNoRows) {
+			return id, nil
+		}
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		id = into
+	}
+}
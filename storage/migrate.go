@@ -0,0 +1,605 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// migrateDatabase brings databases created by older builds up to the current
+// schema. Every step must be safe to run against an already migrated file.
+func migrateDatabase(db *sql.DB) error {
+	hasSeq, err := hasColumn(db, "records", "seq")
+	if err != nil {
+		return err
+	}
+	if !hasSeq {
+		err = addRecordSequence(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = addColumns(db, "records", addedRecordColumns)
+	if err != nil {
+		return err
+	}
+
+	// versions written before effective time was tracked took effect when
+	// they were recorded
+	_, err = db.Exec(`UPDATE records SET effective_at = created_at WHERE effective_at IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	// ids were unique across the whole table before tenants
+	_, err = db.Exec(`DROP INDEX IF EXISTS records_id_version`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(createRecordsIndexSQL)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range auxiliaryTables {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	err = addColumns(db, "compaction_log", addedCompactionLogColumns)
+	if err != nil {
+		return err
+	}
+	err = addColumns(db, "api_keys", addedAPIKeysColumns)
+	if err != nil {
+		return err
+	}
+	err = addColumns(db, "record_types", addedRecordTypesColumns)
+	if err != nil {
+		return err
+	}
+	err = addColumns(db, "record_merges", addedRecordMergesColumns)
+	if err != nil {
+		return err
+	}
+	// merges made before recorded_at was kept were recorded with the version
+	// holding the combined data
+	_, err = db.Exec(`UPDATE record_merges SET recorded_at = (SELECT created_at FROM records
+		WHERE records.tenant = record_merges.tenant AND records.id = record_merges.into_id AND records.version = record_merges.version)
+		WHERE recorded_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	for _, added := range addedTenantColumns {
+		err = addColumns(db, added, []column{tenantColumn})
+		if err != nil {
+			return err
+		}
+	}
+	for _, keyed := range tenantKeyedTables {
+		err = addTenantKey(db, keyed.table, keyed.create, keyed.columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	return runDataMigrations(db)
+}
+
+const createMigrationsTableSQL = `CREATE TABLE IF NOT EXISTS migrations (
+		"name" TEXT PRIMARY KEY,
+		"applied_at" TIMESTAMP NOT NULL
+	);`
+
+// dataMigration rewrites stored data in a way that cannot safely be repeated,
+// so it is recorded in the migrations table once it has run.
+type dataMigration struct {
+	name string
+	run  func(tx *sql.Tx) error
+}
+
+// dataMigrations run in order, each at most once per database.
+var dataMigrations = []dataMigration{
+	{"unset-deleted-patch-keys", unsetDeletedPatchKeys},
+	{"escape-patch-key-paths", escapePatchKeyPaths},
+	{"seal-existing-versions", sealExistingVersions},
+	{"encode-empty-patch-segments", encodeEmptyPatchSegments},
+	{"seal-tenant-into-hashes", sealTenantIntoHashes},
+}
+
+// runDataMigrations runs the data migrations the database has not had yet.
+func runDataMigrations(db *sql.DB) error {
+	for _, migration := range dataMigrations {
+		if err := runDataMigration(db, migration); err != nil {
+			return fmt.Errorf("%s migration: %w", migration.name, err)
+		}
+	}
+	return nil
+}
+
+// runDataMigration runs migration and records it in one transaction, unless
+// it already ran.
+func runDataMigration(db *sql.DB, migration dataMigration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = ?`, migration.name).Scan(&applied)
+	if err != nil || applied > 0 {
+		return err
+	}
+	log.Printf("Running %s migration...", migration.name)
+	if err := migration.run(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO migrations (name, applied_at) VALUES (?, ?)`, migration.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// unsetDeletedPatchKeys rewrites the patches stored while values were only
+// strings, in which null deleted a key, so they use entity.Unset instead and
+// null can be a value.
+func unsetDeletedPatchKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT seq, patch FROM records WHERE patch LIKE '%null%'`)
+	if err != nil {
+		return err
+	}
+	rewritten := map[int64]string{}
+	for rows.Next() {
+		var seq int64
+		var patch string
+		if err := rows.Scan(&seq, &patch); err != nil {
+			rows.Close()
+			return err
+		}
+		var legacy map[string]*string
+		if err := json.Unmarshal([]byte(patch), &legacy); err != nil {
+			rows.Close()
+			return fmt.Errorf("patch of version %d: %w", seq, err)
+		}
+		converted := entity.Patch{}
+		for key, value := range legacy {
+			if value == nil {
+				converted[key] = entity.Unset
+			} else {
+				converted[key] = *value
+			}
+		}
+		patchBytes, err := json.Marshal(converted)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rewritten[seq] = string(patchBytes)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return updatePatches(tx, rewritten)
+}
+
+// escapePatchKeyPaths escapes the keys of patches stored while records were
+// flat, now that patch keys are paths, so a key such as "a.b" still
+// addresses a top-level key instead of a nested one.
+func escapePatchKeyPaths(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT seq, patch FROM records WHERE patch LIKE '%.%' OR patch LIKE '%\%'`)
+	if err != nil {
+		return err
+	}
+	rewritten := map[int64]string{}
+	for rows.Next() {
+		var seq int64
+		var patch string
+		if err := rows.Scan(&seq, &patch); err != nil {
+			rows.Close()
+			return err
+		}
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(patch), &members); err != nil {
+			rows.Close()
+			return fmt.Errorf("patch of version %d: %w", seq, err)
+		}
+		escaped := map[string]json.RawMessage{}
+		changed := false
+		for key, value := range members {
+			path := entity.JoinPath(key)
+			escaped[path] = value
+			changed = changed || path != key
+		}
+		if !changed {
+			continue
+		}
+		patchBytes, err := json.Marshal(escaped)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rewritten[seq] = string(patchBytes)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return updatePatches(tx, rewritten)
+}
+
+// encodeEmptyPatchSegments rewrites the patch keys stored before empty keys
+// had an encoding, in which an empty key left an empty segment, such as "" or
+// "a.", that no path could parse.
+func encodeEmptyPatchSegments(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT seq, patch FROM records WHERE patch IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	rewritten := map[int64]string{}
+	for rows.Next() {
+		var seq int64
+		var patch string
+		if err := rows.Scan(&seq, &patch); err != nil {
+			rows.Close()
+			return err
+		}
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(patch), &members); err != nil {
+			rows.Close()
+			return fmt.Errorf("patch of version %d: %w", seq, err)
+		}
+		encoded := map[string]json.RawMessage{}
+		changed := false
+		for key, value := range members {
+			if _, err := entity.ParsePath(key); err != nil {
+				key = entity.JoinPath(splitLegacyPath(key)...)
+				changed = true
+			}
+			encoded[key] = value
+		}
+		if !changed {
+			continue
+		}
+		patchBytes, err := json.Marshal(encoded)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rewritten[seq] = string(patchBytes)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return updatePatches(tx, rewritten)
+}
+
+// splitLegacyPath splits a path as it was written before empty keys had an
+// encoding: on unescaped dots, with empty segments kept.
+func splitLegacyPath(path string) []string {
+	var segments []string
+	var segment strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path):
+			segment.WriteByte(path[i+1])
+			i++
+		case c == '.':
+			segments = append(segments, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(c)
+		}
+	}
+	return append(segments, segment.String())
+}
+
+// updatePatches stores new patches by sequence number.
+func updatePatches(tx *sql.Tx, patches map[int64]string) error {
+	for seq, patch := range patches {
+		_, err := tx.Exec(`UPDATE records SET patch = ? WHERE seq = ?`, patch, seq)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealExistingVersions hashes the versions written before the hash chain was
+// kept. It runs once; a version found without a hash after that was not
+// written through the storage layer, and VerifyChain reports it as broken.
+func sealExistingVersions(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT seq FROM records WHERE hash IS NULL ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	var seqs []int64
+	for rows.Next() {
+		var seq int64
+		if err := rows.Scan(&seq); err != nil {
+			rows.Close()
+			return err
+		}
+		seqs = append(seqs, seq)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := sealVersionsIn(tx, seqs); err != nil {
+		return err
+	}
+	if len(seqs) > 0 {
+		log.Printf("Hashed %d existing record versions", len(seqs))
+	}
+	return nil
+}
+
+// sealTenantIntoHashes seals again the versions of tenants other than the
+// default one that were hashed before the hash covered the tenant. A record
+// whose chain does not verify under the old hash is left as it is, so
+// VerifyChain still reports the break; for every record it seals again, a
+// checkpoint mapping the old hashes to the new ones is appended to
+// reseal_log.
+func sealTenantIntoHashes(tx *sql.Tx) error {
+	versions, err := queryRecordsIn(tx, `SELECT `+recordColumns+` FROM records WHERE tenant != '' ORDER BY tenant, id, seq`)
+	if err != nil {
+		return err
+	}
+
+	resealed := 0
+	for start := 0; start < len(versions); {
+		end := start + 1
+		for end < len(versions) && versions[end].tenant == versions[start].tenant && versions[end].ID == versions[start].ID {
+			end++
+		}
+		sealed, err := sealTenantIntoRecordIn(tx, versions[start:end])
+		if err != nil {
+			return err
+		}
+		if sealed {
+			resealed++
+		}
+		start = end
+	}
+	if resealed > 0 {
+		log.Printf("Sealed the tenant into the hashes of %d records", resealed)
+	}
+	return nil
+}
+
+// sealTenantIntoRecordIn hashes versions, all of one record in sequence
+// order, again with their tenant. It reports false without changing anything
+// if their chain does not verify as it was hashed before.
+func sealTenantIntoRecordIn(tx *sql.Tx, versions []*storedRecord) (bool, error) {
+	tenant, id := versions[0].tenant, versions[0].ID
+	compacted, err := compactedHashesIn(tx, tenant, id)
+	if err != nil {
+		return false, err
+	}
+	head := ""
+	for _, version := range versions {
+		if reason := chainBreak("", version.Record, head, compacted); reason != "" {
+			log.Printf("Not sealing record %d of tenant %q again: version %d: %s", id, tenant, version.Version, reason)
+			return false, nil
+		}
+		head = version.Hash
+	}
+
+	checkpoint := entity.ResealCheckpoint{Tenant: tenant, RecordID: id, Action: "hash.tenant", ResealedAt: time.Now().UTC()}
+	// the first version keeps linking across versions compacted before it
+	prevHash := versions[0].PrevHash
+	for _, version := range versions {
+		hash := version.ComputeHash(tenant, prevHash)
+		_, err := tx.Exec(`UPDATE records SET hash = ?, prev_hash = ? WHERE seq = ?`, hash, nullString(prevHash), version.Seq)
+		if err != nil {
+			return false, err
+		}
+		checkpoint.Versions = append(checkpoint.Versions, entity.ResealedVersion{
+			Seq: version.Seq, Version: version.Version, OldHash: version.Hash, Hash: hash,
+		})
+		prevHash = hash
+	}
+
+	payload, err := json.Marshal(checkpoint)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(`INSERT INTO reseal_log (tenant, id, resealed_at, checkpoint) VALUES (?, ?, ?, ?)`,
+		tenant, id, checkpoint.ResealedAt, string(payload))
+	return err == nil, err
+}
+
+// column is a column added to a table after it was first created.
+type column struct {
+	name       string
+	definition string
+}
+
+// addColumns adds the columns table does not have yet.
+func addColumns(db *sql.DB, table string, columns []column) error {
+	for _, column := range columns {
+		exists, err := hasColumn(db, table, column.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Printf("Adding %s.%s column...", table, column.name)
+			_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN "` + column.name + `" ` + column.definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// auxiliaryTables create the tables that sit beside records. They only use
+// IF NOT EXISTS, so new features add their table here.
+var auxiliaryTables = []string{
+	createRecordTagsTableSQL,
+	createCompactionLogTableSQL,
+	createAuditLogTableSQL,
+	createLegalHoldsTableSQL,
+	createAPIKeysTableSQL,
+	createAccessLogTableSQL,
+	createMigrationsTableSQL,
+	createRecordTypesTableSQL,
+	createTypedRecordsTableSQL,
+	createRecordIDsTableSQL,
+	createResealLogTableSQL,
+	createRecordAliasesTableSQL,
+	createRecordLinksTableSQL,
+	createRecordMergesTableSQL,
+	createRecordSplitsTableSQL,
+}
+
+// addedRecordColumns are the columns added to records after the versioned
+// schema was introduced, oldest first.
+var addedRecordColumns = []column{
+	{"operation", "TEXT"},
+	{"source_version", "integer"},
+	{"effective_at", "TIMESTAMP"},
+	{"superseded_at", "TIMESTAMP"},
+	{"patch", "TEXT"},
+	{"parent_seq", "integer"},
+	{"delta_depth", "integer NOT NULL DEFAULT 0"},
+	{"hash", "TEXT"},
+	{"prev_hash", "TEXT"},
+	{"author", "TEXT"},
+	tenantColumn,
+}
+
+// addedCompactionLogColumns are the columns added to compaction_log after it
+// was introduced, oldest first.
+var addedCompactionLogColumns = []column{
+	{"hash", "TEXT"},
+	{"prev_hash", "TEXT"},
+	tenantColumn,
+}
+
+// addedAPIKeysColumns are the columns added to api_keys after it was
+// introduced, oldest first.
+var addedAPIKeysColumns = []column{
+	{"roles", "TEXT"},
+	tenantColumn,
+}
+
+// addedRecordTypesColumns are the columns added to record_types after it was
+// introduced, oldest first.
+var addedRecordTypesColumns = []column{
+	{"migration", "TEXT"},
+}
+
+// addedRecordMergesColumns are the columns added to record_merges after it
+// was introduced, oldest first.
+var addedRecordMergesColumns = []column{
+	{"recorded_at", "TIMESTAMP"},
+}
+
+// tenantColumn is added to every table created before tenants. Rows written
+// until then belong to the default tenant.
+var tenantColumn = column{"tenant", "TEXT NOT NULL DEFAULT ''"}
+
+// addedTenantColumns are the tables that only gained tenantColumn after they
+// were introduced.
+var addedTenantColumns = []string{"audit_log", "legal_holds", "access_log"}
+
+// tenantKeyedTables are the tables whose primary key gained the tenant, along
+// with the columns they had before.
+var tenantKeyedTables = []struct {
+	table   string
+	create  string
+	columns string
+}{
+	{"record_tags", createRecordTagsTableSQL, "id, tag"},
+	{"typed_records", createTypedRecordsTableSQL, "id, type"},
+	{"record_types", createRecordTypesTableSQL, recordTypeColumns},
+}
+
+// addTenantKey rebuilds a table created before tenants, which a column cannot
+// simply be added to as its primary key changes. Existing rows belong to the
+// default tenant.
+func addTenantKey(db *sql.DB, table, create, columns string) error {
+	exists, err := hasColumn(db, table, "tenant")
+	if err != nil || exists {
+		return err
+	}
+	log.Printf("Adding tenant to the %s key...", table)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`ALTER TABLE ` + table + ` RENAME TO ` + table + `_untenanted`,
+		create,
+		`INSERT INTO ` + table + ` (` + columns + `) SELECT ` + columns + ` FROM ` + table + `_untenanted`,
+		`DROP TABLE ` + table + `_untenanted`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// hasColumn reports whether table has a column with the given name.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// addRecordSequence rebuilds the original records table, which only had
+// id, data and timestamps, so every row gets a stable sequence number and a
+// per-record version. Existing rows are numbered in insertion order.
+func addRecordSequence(db *sql.DB) error {
+	log.Println("Migrating records table to versioned schema...")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`ALTER TABLE records RENAME TO records_unversioned`,
+		createRecordsTableSQL,
+		`INSERT INTO records (id, version, data, created_at, deleted_at)
+			SELECT id, ROW_NUMBER() OVER (PARTITION BY id ORDER BY rowid), data, created_at, deleted_at
+			FROM records_unversioned ORDER BY rowid`,
+		`DROP TABLE records_unversioned`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// GetRecordIDs returns the id of every record of the tenant in ascending
+// order.
+func (s *Storage) GetRecordIDs() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT id FROM records WHERE tenant = ? ORDER BY id`, s.tenant)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+const createResealLogTableSQL = `CREATE TABLE IF NOT EXISTS reseal_log (
+		"seq" integer PRIMARY KEY AUTOINCREMENT,
+		"tenant" TEXT NOT NULL,
+		"id" integer NOT NULL,
+		"resealed_at" TIMESTAMP NOT NULL,
+		"checkpoint" TEXT NOT NULL,
+		"key_id" TEXT,
+		"signature" TEXT
+	);
+	CREATE TRIGGER IF NOT EXISTS reseal_log_no_update BEFORE UPDATE ON reseal_log
+	BEGIN SELECT RAISE(ABORT, 'reseal_log is append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS reseal_log_no_delete BEFORE DELETE ON reseal_log
+	BEGIN SELECT RAISE(ABORT, 'reseal_log is append-only'); END;`
+
+// ErrBrokenChain is returned when a record whose hash chain does not verify
+// would be sealed again, which would hide the break.
+var ErrBrokenChain = errors.New("hash chain of the record is broken")
+
+// RewriteRecordData replaces the data of every version of a record, oldest
+// first, with what rewrite returns for it. It is meant for changes to how
+// values are stored, such as re-encrypting them, not to what they mean.
+//
+// The record's hash chain is verified first; the rewrite is refused with
+// ErrBrokenChain if it does not hold and with ErrLegalHold while the record
+// is under an active hold. Stored patches are recomputed from the rewritten
+// data and the hash chain of the record is sealed again from the first
+// changed version. The hashes the versions had before are kept in a
+// checkpoint in reseal_log, signed by sign when it is not nil, and entry is
+// written to the audit trail with the number of versions rewritten prepended
+// to its detail. It returns that number.
+func (s *Storage) RewriteRecordData(id int64, rewrite func(record *entity.Record) (map[string]interface{}, error), entry entity.AuditEntry, sign func(payload []byte) (keyID, signature string)) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	versions, err := queryRecordsIn(tx, `SELECT `+recordColumns+` FROM records WHERE tenant = ? AND id = ? ORDER BY seq`, s.tenant, id)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	compacted, err := compactedHashesIn(tx, s.tenant, id)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	head := ""
+	for _, version := range versions {
+		if reason := chainBreak(s.tenant, version.Record, head, compacted); reason != "" {
+			return 0, fmt.Errorf("%w: version %d of record %d: %s", ErrBrokenChain, version.Version, id, reason)
+		}
+		head = version.Hash
+	}
+
+	rewritten := map[int64]map[string]interface{}{}
+	changed := 0
+	prevHash := ""
+	checkpoint := entity.ResealCheckpoint{Tenant: s.tenant, RecordID: id, Action: entry.Action}
+	for _, version := range versions {
+		data, err := rewrite(version.Record)
+		if err != nil {
+			return 0, err
+		}
+		rewritten[version.Seq] = data
+		dataChanged := len(entity.Diff(version.Data, data)) > 0
+		if dataChanged {
+			if changed == 0 {
+				err = checkNoHoldIn(tx, s.tenant, id)
+				if err != nil {
+					return 0, err
+				}
+			}
+			changed++
+		}
+
+		sealed := version.Record.Copy()
+		sealed.Data = data
+		// the first version keeps linking across versions compacted before it
+		if prevHash == "" {
+			prevHash = version.PrevHash
+		}
+		hash := sealed.ComputeHash(s.tenant, prevHash)
+		if !dataChanged && hash == version.Hash && prevHash == version.PrevHash {
+			prevHash = hash
+			continue
+		}
+
+		// snapshots keep the full data; versions stored before patches were
+		// kept have none
+		var storedData, storedPatch interface{}
+		if version.depth == 0 {
+			dataBytes, err := json.Marshal(data)
+			if err != nil {
+				return 0, err
+			}
+			storedData = string(dataBytes)
+		}
+		if version.Patch != nil {
+			parentData, ok := rewritten[version.parentSeq]
+			if version.parentSeq != 0 && !ok {
+				return 0, fmt.Errorf("%w: version %d of record %d", ErrBrokenDeltaChain, version.Version, id)
+			}
+			patchBytes, err := json.Marshal(entity.Diff(parentData, data))
+			if err != nil {
+				return 0, err
+			}
+			storedPatch = string(patchBytes)
+		}
+
+		_, err = tx.Exec(`UPDATE records SET data = ?, patch = ?, hash = ?, prev_hash = ? WHERE seq = ?`,
+			storedData, storedPatch, hash, nullString(prevHash), version.Seq)
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		checkpoint.Versions = append(checkpoint.Versions, entity.ResealedVersion{
+			Seq: version.Seq, Version: version.Version, OldHash: version.Hash, Hash: hash,
+		})
+		prevHash = hash
+	}
+
+	if changed > 0 {
+		checkpoint.ResealedAt = time.Now().UTC()
+		payload, err := json.Marshal(checkpoint)
+		if err != nil {
+			return 0, err
+		}
+		var keyID, signature string
+		if sign != nil {
+			keyID, signature = sign(payload)
+		}
+		_, err = tx.Exec(`INSERT INTO reseal_log (tenant, id, resealed_at, checkpoint, key_id, signature) VALUES (?, ?, ?, ?, ?, ?)`,
+			s.tenant, id, checkpoint.ResealedAt, string(payload), nullString(keyID), nullString(signature))
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+
+		entry.RecordID = id
+		entry.Detail = fmt.Sprintf("%d versions rewritten, %s", changed, entry.Detail)
+		err = appendAuditIn(tx, s.tenant, entry)
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+	}
+
+	return changed, tx.Commit()
+}
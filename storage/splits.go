@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// keys is the JSON array of the top-level keys split off
+const createRecordSplitsTableSQL = `CREATE TABLE IF NOT EXISTS record_splits (
+		"split_id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"source_id" integer NOT NULL,
+		"source_version" integer NOT NULL,
+		"new_id" integer NOT NULL,
+		"keys" TEXT NOT NULL,
+		"moved" BOOLEAN NOT NULL,
+		"split_at" TIMESTAMP NOT NULL,
+		"split_by" TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS record_splits_source ON record_splits (tenant, source_id);
+	CREATE UNIQUE INDEX IF NOT EXISTS record_splits_new ON record_splits (tenant, new_id);`
+
+const splitColumns = `split_id, source_id, source_version, new_id, keys, moved, split_at, split_by`
+
+func scanSplit(row scanner) (*entity.Split, error) {
+	split := &entity.Split{}
+	var keys string
+	err := row.Scan(&split.ID, &split.SourceID, &split.SourceVersion, &split.NewID, &keys, &split.Moved, &split.SplitAt, &split.SplitBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(keys), &split.Keys); err != nil {
+		return nil, err
+	}
+	split.SplitAt = split.SplitAt.UTC()
+	return split, nil
+}
+
+// SplitRecord stores data as the first version of split.NewID, which must
+// not exist yet or ErrRecordExists is returned, and records its lineage,
+// auditing both records. If remaining is not nil, it is stored as a new
+// version of split.SourceID as well, without the keys split off; sourceSeq
+// must still be its current version, the one remaining was taken from, or
+// ErrRecordChanged is returned. Both versions get meta. The new version of
+// the source, if any, is returned after the new record.
+func (s *Storage) SplitRecord(split entity.Split, data, remaining map[string]interface{}, sourceSeq int64, meta VersionMeta) (*entity.Split, *entity.Record, *entity.Record, error) {
+	log.Println("Splitting record...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, nil, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	var source *entity.Record
+	if remaining != nil {
+		latest, err := currentVersionIn(tx, s.tenant, split.SourceID, sourceSeq)
+		if err != nil {
+			log.Println(err)
+			return nil, nil, nil, err
+		}
+		stored, err := s.insertVersionIn(tx, split.SourceID, remaining, latest, now, meta)
+		if err != nil {
+			log.Println(err)
+			return nil, nil, nil, err
+		}
+		source = stored.Record
+	}
+	if _, err := latestRecordIn(tx, s.tenant, split.NewID); !errors.Is(err, sql.ErrNoRows) {
+		if err == nil {
+			err = ErrRecordExists
+		}
+		log.Println(err)
+		return nil, nil, nil, err
+	}
+	created, err := s.insertVersionIn(tx, split.NewID, data, nil, now, meta)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, nil, err
+	}
+
+	keys, err := json.Marshal(split.Keys)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stored, err := scanSplit(tx.QueryRow(`INSERT INTO record_splits (tenant, source_id, source_version, new_id, keys, moved, split_at, split_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING `+splitColumns,
+		s.tenant, split.SourceID, split.SourceVersion, split.NewID, string(keys), remaining != nil, now, split.SplitBy))
+	if err != nil {
+		log.Println(err)
+		return nil, nil, nil, err
+	}
+	detail := fmt.Sprintf("%s of %d version %d split into %d", strings.Join(stored.Keys, ", "), stored.SourceID, stored.SourceVersion, stored.NewID)
+	for _, id := range []int64{stored.SourceID, stored.NewID} {
+		err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+			At:       now,
+			Actor:    stored.SplitBy,
+			Action:   "record.split",
+			RecordID: id,
+			Detail:   detail,
+		})
+		if err != nil {
+			log.Println(err)
+			return nil, nil, nil, err
+		}
+	}
+
+	return stored, created.Record, source, tx.Commit()
+}
+
+// GetSplitOrigin returns the split the record was created by, or
+// sql.ErrNoRows if it was not split off another.
+func (s *Storage) GetSplitOrigin(id int64) (*entity.Split, error) {
+	log.Println("Getting split origin...")
+	return scanSplit(s.db.QueryRow(`SELECT `+splitColumns+` FROM record_splits
+		WHERE tenant = ? AND new_id = ?`, s.tenant, id))
+}
+
+// GetSplits returns the splits of the record into others and the split it
+// was created by, oldest first.
+func (s *Storage) GetSplits(id int64) ([]entity.Split, error) {
+	log.Println("Getting splits...")
+	rows, err := s.db.Query(`SELECT `+splitColumns+` FROM record_splits
+		WHERE tenant = ? AND (source_id = ? OR new_id = ?) ORDER BY split_id`, s.tenant, id, id)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	splits := []entity.Split{}
+	for rows.Next() {
+		split, err := scanSplit(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		splits = append(splits, *split)
+	}
+	return splits, rows.Err()
+}
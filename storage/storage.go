@@ -3,6 +3,8 @@ package storage
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -13,44 +15,113 @@ import (
 
 const databaseFile = "sqlite-database.db"
 
+// recordColumns lists the columns every record query selects, in the order
+// scanRecord expects them.
+const recordColumns = `seq, id, version, data, created_at, deleted_at, operation, source_version,
+	effective_at, superseded_at, patch, parent_seq, delta_depth, hash, prev_hash, author, tenant`
+
+// Storage reads and writes the records of one tenant. Every tenant numbers
+// its records independently, so the same id in two tenants names two
+// different records. NewStorageAt returns the default tenant, "".
 type Storage struct {
-	db *sql.DB
+	db               *sql.DB
+	snapshotInterval int
+	tenant           string
 }
 
 func NewStorage() (*Storage, error) {
+	return NewStorageAt(databaseFile)
+}
+
+// NewStorageAt opens the SQLite database at path, creating and migrating it
+// as needed.
+func NewStorageAt(path string) (*Storage, error) {
 	log.Println("Initializing storage")
 
-	if _, err := os.Stat(databaseFile); err == nil {
+	if _, err := os.Stat(path); err == nil {
 		log.Println("Database file already exists, skipping creation.")
 	} else {
-		err = createDatabase()
+		err = createDatabase(path)
 		if err != nil {
 			log.Println(err)
 			return nil, err
 		}
-		log.Println(databaseFile, "created")
+		log.Println(path, "created")
+	}
+
+	// writers take the lock up front so versions are numbered and chained
+	// consistently when requests race
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", "./"+databaseFile)
+	err = migrateDatabase(db)
+	if err != nil {
+		log.Println(err)
+		db.Close()
+		return nil, err
+	}
+
+	return &Storage{db: db, snapshotInterval: DefaultSnapshotInterval}, nil
+}
+
+// SetSnapshotInterval sets how many versions may be stored as patches before
+// the full data is written again. Values below 1 store every version in full.
+func (s *Storage) SetSnapshotInterval(interval int) {
+	s.snapshotInterval = interval
+}
+
+// WithTenant returns a view of the same database that only sees the records,
+// and everything attached to them, of tenant.
+func (s *Storage) WithTenant(tenant string) *Storage {
+	scoped := *s
+	scoped.tenant = tenant
+	return &scoped
+}
+
+// Tenant returns the tenant s is scoped to.
+func (s *Storage) Tenant() string {
+	return s.tenant
+}
+
+// GetTenants returns every tenant that has records, in alphabetical order.
+func (s *Storage) GetTenants() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT tenant FROM records ORDER BY tenant`)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
 
-	return &Storage{db: db}, nil
+// Close releases the underlying database handle.
+func (s *Storage) Close() error {
+	return s.db.Close()
 }
 
-func createDatabase() error {
-	os.Remove(databaseFile) // Delete the file to avoid duplicated records.
+func createDatabase(path string) error {
+	os.Remove(path) // Delete the file to avoid duplicated records.
 
-	file, err := os.Create(databaseFile) // Create SQLite file
+	file, err := os.Create(path) // Create SQLite file
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 	file.Close()
 
-	db, err := sql.Open("sqlite3", "./"+databaseFile) // Open the created SQLite file
+	db, err := sql.Open("sqlite3", "file:"+path) // Open the created SQLite file
 	if err != nil {
 		log.Println(err)
 		return err
@@ -66,201 +137,388 @@ func createDatabase() error {
 	return nil
 }
 
-func createTable(db *sql.DB) error {
-	createRecordsTableSQL := `CREATE TABLE records (
+const createRecordsTableSQL = `CREATE TABLE records (
+		"seq" INTEGER PRIMARY KEY AUTOINCREMENT,
 		"id" integer NOT NULL,
+		"version" integer NOT NULL,
 		"data" TEXT,
 		"created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		"deleted_at" TIMESTAMP	
-	);` // SQL Statement for Create Table
+		"deleted_at" TIMESTAMP,
+		"operation" TEXT,
+		"source_version" integer,
+		"effective_at" TIMESTAMP,
+		"superseded_at" TIMESTAMP,
+		"patch" TEXT,
+		"parent_seq" integer,
+		"delta_depth" integer NOT NULL DEFAULT 0,
+		"hash" TEXT,
+		"prev_hash" TEXT,
+		"author" TEXT,
+		"tenant" TEXT NOT NULL DEFAULT ''
+	);`
+
+const createRecordsIndexSQL = `CREATE UNIQUE INDEX IF NOT EXISTS records_tenant_id_version ON records (tenant, id, version);`
 
+func createTable(db *sql.DB) error {
 	log.Println("Creating records table...")
-	_, err := db.Exec(createRecordsTableSQL) // Execute SQL Statement
-	if err != nil {
-		log.Println(err)
-		return err
+	for _, stmt := range []string{createRecordsTableSQL, createRecordsIndexSQL} {
+		_, err := db.Exec(stmt) // Execute SQL Statement
+		if err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 	log.Println("Records table created")
 
 	return nil
 }
 
-func (s *Storage) InsertRecord(id int, data string) (int, error) {
-	log.Println("Inserting record...")
-	insertRecordSQL := `INSERT INTO records (id, data) VALUES (?, ?)`
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	statement, err := s.db.Prepare(insertRecordSQL)
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// queryRecords runs query and returns every resulting version, materialized.
+func (s *Storage) queryRecords(query string, args ...interface{}) ([]*entity.Record, error) {
+	stored, err := queryRecordsIn(s.db, query, args...)
 	if err != nil {
 		log.Println(err)
-		return 0, err
+		return nil, err
 	}
-	defer statement.Close()
+	return records(stored), nil
+}
 
-	_, err = statement.Exec(id, data)
+// queryRecordsIn runs query on q, which may be a transaction, and returns
+// every resulting version, materialized.
+func queryRecordsIn(q querier, query string, args ...interface{}) ([]*storedRecord, error) {
+	rows, err := q.Query(query, args...)
 	if err != nil {
-		log.Println(err)
-		return 0, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return 0, nil
-}
+	var stored []*storedRecord
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-func (s *Storage) GetRecordsByID(id int) ([]*entity.Record, error) {
-	log.Println("Getting record...")
-	getRecordSQL := `SELECT id, data, created_at, deleted_at FROM records WHERE id = ?`
+	return stored, materializeIn(q, stored, nil)
+}
 
-	statement, err := s.db.Prepare(getRecordSQL)
+// queryRecordIn runs a query on q expected to return a single version and
+// materializes it. It returns sql.ErrNoRows when nothing matches.
+func queryRecordIn(q querier, query string, args ...interface{}) (*storedRecord, error) {
+	record, err := scanRecord(q.QueryRow(query, args...))
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	defer statement.Close()
-	var records []*entity.Record
-	rows, err := statement.Query(id)
+	return record, materializeIn(q, []*storedRecord{record}, nil)
+}
+
+// VersionMeta describes how a new version came to be.
+type VersionMeta struct {
+	// Operation is one of the entity.Operation constants.
+	Operation string
+	// SourceVersion is the earlier version this one was derived from, such
+	// as the target of a revert.
+	SourceVersion int
+	// EffectiveAt is when the data became true. It defaults to the time the
+	// version is recorded.
+	EffectiveAt time.Time
+	// Author is who wrote the version, if known.
+	Author string
+	// Type is the record type the record must have. A record without a type
+	// is given it; one with another type is refused with ErrTypeConflict.
+	Type string
+	// BaseSeq, if set, is the stored version the new data was derived from.
+	// It must still be the record's current version; otherwise
+	// ErrRecordChanged is returned.
+	BaseSeq int64
+}
+
+// nullString stores empty strings as NULL.
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// nullInt stores zero as NULL.
+func nullInt(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// nullInt64 stores zero as NULL.
+func nullInt64(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(value time.Time) interface{} {
+	if value.IsZero() {
+		return nil
+	}
+	return value.UTC()
+}
+
+// currentTimeline selects the versions that make up what is known now: a
+// correction supersedes the versions it replays.
+const currentTimeline = `superseded_at IS NULL`
+
+// effectiveOrder and latestEffectiveOrder sort versions by when they took
+// effect, breaking ties by the order they were stored in.
+const effectiveOrder = `effective_at, seq`
+const latestEffectiveOrder = `effective_at DESC, seq DESC`
+
+// latestRecordIn returns the current version of a record of tenant, or
+// sql.ErrNoRows.
+func latestRecordIn(q querier, tenant string, id int64) (*storedRecord, error) {
+	return queryRecordIn(q, `SELECT `+recordColumns+` FROM records
+		WHERE tenant = ? AND id = ? AND `+currentTimeline+`
+		ORDER BY `+latestEffectiveOrder+` LIMIT 1`, tenant, id)
+}
+
+// ErrRecordExists is returned when creating a record that already has a
+// version.
+var ErrRecordExists = errors.New("record already exists")
+
+// ErrRecordChanged is returned when a change was based on a version of a
+// record that is no longer its current one.
+var ErrRecordChanged = errors.New("record changed since it was read")
+
+// currentVersionIn returns the current version of a record of tenant, or
+// ErrRecordChanged if it is not the one stored as seq.
+func currentVersionIn(q querier, tenant string, id int64, seq int64) (*storedRecord, error) {
+	latest, err := latestRecordIn(q, tenant, id)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	for rows.Next() {
-		record := &entity.Record{}
-		var data string
-		var nullableTime sql.NullTime
-		var timestampStr string
-		err = rows.Scan(&record.ID, &data, &timestampStr, &nullableTime)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		timestamp, err := time.Parse(time.RFC3339, timestampStr)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		err = json.Unmarshal([]byte(data), &record.Data)
+	if latest.Seq != seq {
+		return nil, fmt.Errorf("%w: record %d", ErrRecordChanged, id)
+	}
+	return latest, nil
+}
+
+// insertVersionIn appends a version of id holding data on top of parent,
+// which is nil for a record's first version, and returns the stored row.
+// The patch from parent is always kept; the full data is only written when
+// the chain of patches since the last snapshot reaches the snapshot interval.
+func (s *Storage) insertVersionIn(q querier, id int64, data map[string]interface{}, parent *storedRecord, createdAt time.Time, meta VersionMeta) (*storedRecord, error) {
+	var parentData map[string]interface{}
+	var parentSeq interface{}
+	depth := 0
+	if parent != nil {
+		parentData, parentSeq, depth = parent.Data, parent.Seq, parent.depth+1
+	}
+	var storedData interface{}
+	if parent == nil || depth >= s.snapshotInterval {
+		dataBytes, err := json.Marshal(data)
 		if err != nil {
-			log.Println(err)
 			return nil, err
 		}
-		record.CreatedAt = timestamp
-		records = append(records, record)
+		storedData, depth = string(dataBytes), 0
 	}
-	return records, nil
-}
 
-func (s *Storage) GetLastestRecordByID(id int) (*entity.Record, error) {
-	log.Println("Getting latest record...")
-	getRecordSQL := `SELECT id, data, created_at, deleted_at FROM records WHERE id = ? ORDER BY created_at DESC LIMIT 1`
-
-	statement, err := s.db.Prepare(getRecordSQL)
+	patchBytes, err := json.Marshal(entity.Diff(parentData, data))
 	if err != nil {
 		return nil, err
 	}
-	defer statement.Close()
-	row := statement.QueryRow(id)
-	record := &entity.Record{}
-	var data string
-	var nullableTime sql.NullTime
-	var timestampStr string
-	err = row.Scan(&record.ID, &data, &timestampStr, &nullableTime)
-	log.Println(err)
+	effectiveAt := meta.EffectiveAt
+	if effectiveAt.IsZero() {
+		effectiveAt = createdAt
+	}
+
+	// the hash covers the version number, so it is assigned here rather than
+	// by the INSERT
+	var version int
+	err = q.QueryRow(`SELECT COALESCE(MAX(version), 0) + 1 FROM records WHERE tenant = ? AND id = ?`, s.tenant, id).Scan(&version)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	timestamp, err := time.Parse(time.RFC3339, timestampStr)
+	prevHash, err := chainHeadIn(q, s.tenant, id, 0)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	err = json.Unmarshal([]byte(data), &record.Data)
+	hash := (&entity.Record{
+		ID:          id,
+		Version:     version,
+		Data:        data,
+		CreatedAt:   createdAt,
+		EffectiveAt: effectiveAt,
+		Author:      meta.Author,
+	}).ComputeHash(s.tenant, prevHash)
+
+	record, err := scanRecord(q.QueryRow(`INSERT INTO records
+		(tenant, id, version, data, created_at, operation, source_version, effective_at, patch, parent_seq, delta_depth, hash, prev_hash, author)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING `+recordColumns,
+		s.tenant, id, version, storedData, createdAt.UTC(), nullString(meta.Operation), nullInt(meta.SourceVersion),
+		effectiveAt.UTC(), string(patchBytes), parentSeq, depth, hash, nullString(prevHash), nullString(meta.Author)))
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	record.CreatedAt = timestamp
-
+	record.Data = data
 	return record, nil
 }
 
-func (s *Storage) GetRecordsByIDBetweenTimestamp(id int, startTime, endTime time.Time) ([]*entity.Record, error) {
-	log.Println("GetRecordsByIDBetweenTimestamp record...")
-	getRecordSQL := `SELECT id, data, created_at, deleted_at FROM records WHERE id = ? AND created_at BETWEEN ? AND ? ORDER BY created_at DESC`
-
-	statement, err := s.db.Prepare(getRecordSQL)
+// InsertRecord appends a new version of the record on top of its current
+// version and returns it as stored. A version with the create operation is
+// only stored as the first version of the record; otherwise ErrRecordExists
+// is returned.
+func (s *Storage) InsertRecord(id int64, data map[string]interface{}, meta VersionMeta) (*entity.Record, error) {
+	log.Println("Inserting record...")
+	tx, err := s.db.Begin()
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
-	defer statement.Close()
-	var records []*entity.Record
-	rows, err := statement.Query(id, startTime, endTime)
-	if err != nil {
+	defer tx.Rollback()
+
+	latest, err := latestRecordIn(tx, s.tenant, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Println(err)
 		return nil, err
 	}
-
-	for rows.Next() {
-		record := &entity.Record{}
-		var data string
-		var nullableTime sql.NullTime
-		var timestampStr string
-		err = rows.Scan(&record.ID, &data, &timestampStr, &nullableTime)
-		if err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		timestamp, err := time.Parse(time.RFC3339, timestampStr)
-		if err != nil {
+	if latest != nil && meta.Operation == entity.OperationCreate {
+		return nil, ErrRecordExists
+	}
+	if meta.BaseSeq != 0 {
+		if _, err := currentVersionIn(tx, s.tenant, id, meta.BaseSeq); err != nil {
 			log.Println(err)
 			return nil, err
 		}
-		err = json.Unmarshal([]byte(data), &record.Data)
-		if err != nil {
+	}
+
+	if meta.Type != "" {
+		if err := assignTypeIn(tx, s.tenant, id, meta.Type); err != nil {
 			log.Println(err)
 			return nil, err
 		}
-		record.CreatedAt = timestamp
-		records = append(records, record)
 	}
-	return records, nil
+
+	record, err := s.insertVersionIn(tx, id, data, latest, time.Now(), meta)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return record.Record, tx.Commit()
 }
 
-func (s *Storage) UpdateRecord(id int, data string) (int, error) {
-	log.Println("Updating record...")
-	updateRecordSQL := `UPDATE records SET data = ? WHERE id = ?`
+func (s *Storage) GetRecordsByID(id int64) ([]*entity.Record, error) {
+	log.Println("Getting record...")
+	getRecordSQL := `SELECT ` + recordColumns + ` FROM records WHERE tenant = ? AND id = ? ORDER BY seq`
 
-	statement, err := s.db.Prepare(updateRecordSQL)
+	return s.queryRecords(getRecordSQL, s.tenant, id)
+}
+
+func (s *Storage) GetLastestRecordByID(id int64) (*entity.Record, error) {
+	log.Println("Getting latest record...")
+	record, err := latestRecordIn(s.db, s.tenant, id)
 	if err != nil {
 		log.Println(err)
-		return 0, err
+		return nil, err
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(data, id)
+	return record.Record, nil
+}
+
+// queryRecord runs a query expected to return a single record version. It
+// returns sql.ErrNoRows when nothing matches.
+func (s *Storage) queryRecord(query string, args ...interface{}) (*entity.Record, error) {
+	record, err := queryRecordIn(s.db, query, args...)
 	if err != nil {
-		log.Println(err)
-		return 0, err
+		return nil, err
 	}
+	return record.Record, nil
+}
+
+// GetRecordByVersion returns one specific version of a record.
+func (s *Storage) GetRecordByVersion(id int64, version int) (*entity.Record, error) {
+	log.Println("Getting record version...")
+	getRecordSQL := `SELECT ` + recordColumns + ` FROM records WHERE tenant = ? AND id = ? AND version = ?`
 
-	return id, nil
+	return s.queryRecord(getRecordSQL, s.tenant, id, version)
 }
 
-func (s *Storage) DeleteRecord(id int) error {
+// GetRecordAsOf returns the version of a record that was in effect at asOf
+// according to what was known at asOf.
+func (s *Storage) GetRecordAsOf(id int64, asOf time.Time) (*entity.Record, error) {
+	return s.GetRecordAsKnownAt(id, asOf, asOf)
+}
+
+// GetRecordAsKnownAt returns the version of a record in effect at asOf,
+// according to what had been recorded by knownAt. Moving knownAt across a
+// correction shows the record before and after it.
+func (s *Storage) GetRecordAsKnownAt(id int64, asOf, knownAt time.Time) (*entity.Record, error) {
+	log.Println("Getting record as of...")
+	getRecordSQL := `SELECT ` + recordColumns + ` FROM records
+		WHERE tenant = ? AND id = ? AND created_at <= ? AND (superseded_at IS NULL OR superseded_at > ?)
+		AND effective_at <= ?
+		ORDER BY ` + latestEffectiveOrder + ` LIMIT 1`
+
+	return s.queryRecord(getRecordSQL, s.tenant, id, knownAt.UTC(), knownAt.UTC(), asOf.UTC())
+}
+
+func (s *Storage) GetRecordsByIDBetweenTimestamp(id int64, startTime, endTime time.Time) ([]*entity.Record, error) {
+	log.Println("GetRecordsByIDBetweenTimestamp record...")
+	getRecordSQL := `SELECT ` + recordColumns + ` FROM records WHERE tenant = ? AND id = ? AND created_at BETWEEN ? AND ? ORDER BY seq DESC`
+
+	return s.queryRecords(getRecordSQL, s.tenant, id, startTime, endTime)
+}
+
+// DeleteRecord marks every version of the record as deleted and audits it as
+// done by actor. It refuses with ErrLegalHold while the record is under an
+// active legal hold.
+func (s *Storage) DeleteRecord(id int64, actor string) error {
 	log.Println("Deleting record...")
-	deleteRecordSQL := `UPDATE records SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
 
-	statement, err := s.db.Prepare(deleteRecordSQL)
+	err = checkNoHoldIn(tx, s.tenant, id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
-	defer statement.Close()
 
-	_, err = statement.Exec(id)
+	deleteRecordSQL := `UPDATE records SET deleted_at = CURRENT_TIMESTAMP WHERE tenant = ? AND id = ?`
+	_, err = tx.Exec(deleteRecordSQL, s.tenant, id)
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	return nil
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{Actor: actor, Action: "record.delete", RecordID: id})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"log"
+)
+
+const createRecordTagsTableSQL = `CREATE TABLE IF NOT EXISTS record_tags (
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"id" integer NOT NULL,
+		"tag" TEXT NOT NULL,
+		PRIMARY KEY (tenant, id, tag)
+	);`
+
+// GetRecordTags returns the tags of a record in alphabetical order.
+func (s *Storage) GetRecordTags(id int64) ([]string, error) {
+	log.Println("Getting record tags...")
+	rows, err := s.db.Query(`SELECT tag FROM record_tags WHERE tenant = ? AND id = ? ORDER BY tag`, s.tenant, id)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// SetRecordTags replaces the tags of a record.
+func (s *Storage) SetRecordTags(id int64, tags []string) error {
+	log.Println("Setting record tags...")
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM record_tags WHERE tenant = ? AND id = ?`, s.tenant, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, tag := range tags {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO record_tags (tenant, id, tag) VALUES (?, ?, ?)`, s.tenant, id, tag)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/temelpa/timetravel/entity"
+)
+
+// ErrTypeConflict is returned when a version assigns a record a type other
+// than the one it already has.
+var ErrTypeConflict = errors.New("record already has a different type")
+
+// record_types is append-only: a changed schema is a new version. Each
+// tenant registers its own types.
+const createRecordTypesTableSQL = `CREATE TABLE IF NOT EXISTS record_types (
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"name" TEXT NOT NULL,
+		"version" integer NOT NULL,
+		"schema" TEXT NOT NULL,
+		"created_at" TIMESTAMP NOT NULL,
+		"created_by" TEXT,
+		"migration" TEXT,
+		PRIMARY KEY (tenant, name, version)
+	);`
+
+const createTypedRecordsTableSQL = `CREATE TABLE IF NOT EXISTS typed_records (
+		"tenant" TEXT NOT NULL DEFAULT '',
+		"id" integer NOT NULL,
+		"type" TEXT NOT NULL,
+		PRIMARY KEY (tenant, id)
+	);`
+
+const recordTypeColumns = `name, version, schema, created_at, created_by, migration`
+
+func scanRecordType(row scanner) (*entity.RecordType, error) {
+	recordType := &entity.RecordType{}
+	var schema string
+	var createdBy, migration sql.NullString
+	err := row.Scan(&recordType.Name, &recordType.Version, &schema, &recordType.CreatedAt, &createdBy, &migration)
+	if err != nil {
+		return nil, err
+	}
+	if migration.Valid {
+		recordType.Migration, err = entity.DecodeMigration([]byte(migration.String))
+		if err != nil {
+			return nil, err
+		}
+	}
+	recordType.Schema = []byte(schema)
+	recordType.CreatedAt = recordType.CreatedAt.UTC()
+	recordType.CreatedBy = createdBy.String
+	return recordType, nil
+}
+
+func queryRecordTypes(q querier, query string, args ...interface{}) ([]entity.RecordType, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recordTypes := []entity.RecordType{}
+	for rows.Next() {
+		recordType, err := scanRecordType(rows)
+		if err != nil {
+			return nil, err
+		}
+		recordTypes = append(recordTypes, *recordType)
+	}
+	return recordTypes, rows.Err()
+}
+
+// AddRecordType stores schema as the next version of the named type, with
+// the migration from the previous version if there is one, and audits it as
+// done by actor.
+func (s *Storage) AddRecordType(name, schema string, migration entity.Migration, actor string) (*entity.RecordType, error) {
+	log.Println("Adding record type...")
+	var migrationJSON interface{}
+	if len(migration) > 0 {
+		migrationBytes, err := json.Marshal(migration)
+		if err != nil {
+			return nil, err
+		}
+		migrationJSON = string(migrationBytes)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	recordType, err := scanRecordType(tx.QueryRow(`INSERT INTO record_types (tenant, name, version, schema, created_at, created_by, migration)
+		VALUES (?, ?, (SELECT COALESCE(MAX(version), 0) + 1 FROM record_types WHERE tenant = ? AND name = ?), ?, ?, ?, ?)
+		RETURNING `+recordTypeColumns, s.tenant, name, s.tenant, name, schema, now, nullString(actor), migrationJSON))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	err = appendAuditIn(tx, s.tenant, entity.AuditEntry{
+		At:     now,
+		Actor:  actor,
+		Action: "record_type.register",
+		Detail: fmt.Sprintf("type %s version %d", name, recordType.Version),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return recordType, tx.Commit()
+}
+
+// GetRecordTypes returns the latest version of every type of the tenant, by
+// name.
+func (s *Storage) GetRecordTypes() ([]entity.RecordType, error) {
+	log.Println("Getting record types...")
+	recordTypes, err := queryRecordTypes(s.db, `SELECT `+recordTypeColumns+` FROM record_types t
+		WHERE tenant = ? AND version = (SELECT MAX(version) FROM record_types WHERE tenant = t.tenant AND name = t.name)
+		ORDER BY name`, s.tenant)
+	if err != nil {
+		log.Println(err)
+	}
+	return recordTypes, err
+}
+
+// GetRecordTypeVersions returns every version of the named type, oldest
+// first. It is empty when the type does not exist.
+func (s *Storage) GetRecordTypeVersions(name string) ([]entity.RecordType, error) {
+	log.Println("Getting record type versions...")
+	recordTypes, err := queryRecordTypes(s.db, `SELECT `+recordTypeColumns+` FROM record_types
+		WHERE tenant = ? AND name = ? ORDER BY version`, s.tenant, name)
+	if err != nil {
+		log.Println(err)
+	}
+	return recordTypes, err
+}
+
+// GetRecordTypeAt returns the version of the named type that applied at at,
+// or sql.ErrNoRows if none had been registered by then.
+func (s *Storage) GetRecordTypeAt(name string, at time.Time) (*entity.RecordType, error) {
+	log.Println("Getting record type...")
+	return scanRecordType(s.db.QueryRow(`SELECT `+recordTypeColumns+` FROM record_types
+		WHERE tenant = ? AND name = ? AND created_at <= ? ORDER BY version DESC LIMIT 1`, s.tenant, name, at.UTC()))
+}
+
+// GetTypeOf returns the type of a record, or "" if it has none.
+func (s *Storage) GetTypeOf(id int64) (string, error) {
+	var recordType string
+	err := s.db.QueryRow(`SELECT type FROM typed_records WHERE tenant = ? AND id = ?`, s.tenant, id).Scan(&recordType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return recordType, err
+}
+
+// assignTypeIn gives record id of tenant its type, which must match the type
+// it already has, if any.
+func assignTypeIn(q querier, tenant string, id int64, recordType string) error {
+	_, err := q.Exec(`INSERT OR IGNORE INTO typed_records (tenant, id, type) VALUES (?, ?, ?)`, tenant, id, recordType)
+	if err != nil {
+		return err
+	}
+	var assigned string
+	err = q.QueryRow(`SELECT type FROM typed_records WHERE tenant = ? AND id = ?`, tenant, id).Scan(&assigned)
+	if err != nil {
+		return err
+	}
+	if assigned != recordType {
+		return fmt.Errorf("%w: %s", ErrTypeConflict, assigned)
+	}
+	return nil
+}